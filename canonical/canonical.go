@@ -0,0 +1,90 @@
+package canonical
+
+import (
+	"farma/dosage"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type Image struct {
+	Main      string `json:"main" bson:"main"`
+	Thumbnail string `json:"thumbnail" bson:"thumbnail"`
+}
+
+// Medicament is the source independent shape of a product. Source and Key
+// are filled in by the parser, mappers fill the rest they know about.
+// Parsed is what the parser read from the title and attributes, the ids are
+// what the normalization dictionaries map MNN and Manufacturer to.
+type Medicament struct {
+	Source         string                 `json:"source" bson:"source"`
+	Key            string                 `json:"key" bson:"key"`
+	Title          string                 `json:"title" bson:"title"`
+	MNN            string                 `json:"mnn" bson:"mnn"`
+	MNNID          string                 `json:"mnn_id,omitempty" bson:"mnn_id,omitempty"`
+	Manufacturer   string                 `json:"manufacturer" bson:"manufacturer"`
+	ManufacturerID string                 `json:"manufacturer_id,omitempty" bson:"manufacturer_id,omitempty"`
+	Dosage         string                 `json:"dosage" bson:"dosage"`
+	PackCount      int                    `json:"pack_count" bson:"pack_count"`
+	Form           string                 `json:"form" bson:"form"`
+	Price          float64                `json:"price" bson:"price"`
+	Currency       string                 `json:"currency" bson:"currency"`
+	Prescription   bool                   `json:"prescription" bson:"prescription"`
+	Images         []*Image               `json:"images" bson:"images"`
+	Groups         []string               `json:"groups" bson:"groups"`
+	Extras         map[string]interface{} `json:"extras,omitempty" bson:"extras,omitempty"`
+	Parsed         *dosage.Dosage         `json:"parsed,omitempty" bson:"parsed,omitempty"`
+}
+
+// Mapper is implemented by raw records of every source.
+type Mapper interface {
+	Canonical() *Medicament
+}
+
+var countRe = regexp.MustCompile(`\d+`)
+
+// Lookup returns the value of attrs named like the first of names it has,
+// ignoring case and surrounding spaces. Sources name the same attribute
+// slightly differently from page to page, so an attribute starting with the
+// name will do when none matches it exactly. The shortest one wins, which
+// is the exact match if there is one.
+func Lookup(attrs map[string]string, names ...string) string {
+	for _, name := range names {
+		name = strings.ToLower(name)
+
+		var best, bestFolded string
+		found := false
+		for attr := range attrs {
+			folded := strings.ToLower(strings.TrimSpace(attr))
+			if !strings.HasPrefix(folded, name) {
+				continue
+			}
+			if !found || len(folded) < len(bestFolded) || len(folded) == len(bestFolded) && attr < best {
+				best, bestFolded, found = attr, folded, true
+			}
+		}
+
+		if found {
+			return strings.TrimSpace(attrs[best])
+		}
+	}
+
+	return ""
+}
+
+// Count returns the first number in s, e.g. 20 for "№20 шт.".
+func Count(s string) int {
+	n, err := strconv.Atoi(countRe.FindString(s))
+	if err != nil {
+		return 0
+	}
+
+	return n
+}
+
+// Prescription tells prescription-only dispensing terms from OTC ones.
+func Prescription(terms string) bool {
+	terms = strings.ToLower(terms)
+
+	return strings.Contains(terms, "рецепт") && !strings.Contains(terms, "без рецепт")
+}
@@ -0,0 +1,11 @@
+package canonical
+
+// Attribute names the HTML sources use for the canonical fields.
+var (
+	MNN_NAMES          = []string{"Действующее вещество", "Активное вещество", "МНН", "Международное непатентованное"}
+	MANUFACTURER_NAMES = []string{"Производитель", "Изготовитель", "Бренд"}
+	DOSAGE_NAMES       = []string{"Дозировка", "Доза"}
+	FORM_NAMES         = []string{"Форма выпуска", "Лекарственная форма", "Форма"}
+	PACK_NAMES         = []string{"Количество в упаковке", "Кол-во в упаковке", "Фасовка", "Количество"}
+	PRESCRIPTION_NAMES = []string{"Отпуск из аптек", "Условия отпуска", "Отпуск", "Рецепт"}
+)
@@ -0,0 +1,177 @@
+package checkpoint
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const SAVE_EVERY int = 100
+
+// State is what is persisted between runs of one crawl. Visited pages are
+// stored apart from it, one by one as they are visited, and only filled in
+// by Load.
+type State struct {
+	ID         string    `json:"id" bson:"_id"`
+	Discovered bool      `json:"discovered" bson:"discovered"`
+	Frontier   []string  `json:"frontier" bson:"frontier"`
+	Visited    []string  `json:"-" bson:"-"`
+	UpdatedAt  time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+type Store interface {
+	// Load returns nil state if nothing was saved under id yet.
+	Load(id string) (*State, error)
+	Save(state *State) error
+	// Visit adds hrefs to the visited pages of id.
+	Visit(id string, hrefs []string) error
+	// Clear forgets the visited pages of id.
+	Clear(id string) error
+}
+
+// Checkpoint tracks the crawl frontier and visited pages of a running
+// crawl and saves them to the store every SAVE_EVERY changes.
+type Checkpoint struct {
+	mu         sync.Mutex
+	store      Store
+	id         string
+	discovered bool
+	frontier   []string
+	visited    map[string]bool
+	claimed    map[string]bool
+	pending    []string
+	changes    int
+}
+
+// Open starts a fresh checkpoint, or continues the saved one when resume is
+// set. A nil store keeps the checkpoint in memory only.
+func Open(store Store, id string, resume bool) (*Checkpoint, error) {
+	c := &Checkpoint{
+		store:   store,
+		id:      id,
+		visited: map[string]bool{},
+		claimed: map[string]bool{},
+	}
+
+	if store == nil {
+		return c, nil
+	}
+	if !resume {
+		return c, store.Clear(id)
+	}
+
+	state, err := store.Load(id)
+	if err != nil || state == nil {
+		return c, err
+	}
+
+	c.discovered = state.Discovered
+	c.frontier = state.Frontier
+	for _, href := range state.Visited {
+		c.visited[href] = true
+		c.claimed[href] = true
+	}
+
+	return c, nil
+}
+
+// Claim reserves href for the caller. It returns false if href was already
+// visited or is being fetched by someone else.
+func (c *Checkpoint) Claim(href string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.claimed[href] {
+		return false
+	}
+	c.claimed[href] = true
+
+	return true
+}
+
+// Visit marks href as fully processed.
+func (c *Checkpoint) Visit(href string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.visited[href] {
+		c.pending = append(c.pending, href)
+	}
+	c.visited[href] = true
+	c.claimed[href] = true
+	c.changed()
+}
+
+// Frontier returns the listing pages still to crawl and whether discovery
+// already happened.
+func (c *Checkpoint) Frontier() ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string{}, c.frontier...), c.discovered
+}
+
+func (c *Checkpoint) SetFrontier(hrefs []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.discovered = true
+	c.frontier = append([]string{}, hrefs...)
+	c.changed()
+}
+
+// Done removes href from the frontier.
+func (c *Checkpoint) Done(href string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, h := range c.frontier {
+		if h == href {
+			c.frontier = append(c.frontier[:i], c.frontier[i+1:]...)
+			break
+		}
+	}
+	c.changed()
+}
+
+func (c *Checkpoint) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.save()
+}
+
+func (c *Checkpoint) changed() {
+	c.changes++
+	if c.changes < SAVE_EVERY {
+		return
+	}
+
+	if err := c.save(); err != nil {
+		log.Printf("checkpoint `%s` not saved: %v", c.id, err)
+	}
+}
+
+// save stores the frontier and the pages visited since the last save. The
+// pages stay pending until the store takes them.
+func (c *Checkpoint) save() error {
+	c.changes = 0
+	if c.store == nil {
+		c.pending = nil
+		return nil
+	}
+
+	if len(c.pending) > 0 {
+		if err := c.store.Visit(c.id, c.pending); err != nil {
+			return err
+		}
+		c.pending = nil
+	}
+
+	return c.store.Save(&State{
+		ID:         c.id,
+		Discovered: c.discovered,
+		Frontier:   c.frontier,
+		UpdatedAt:  time.Now(),
+	})
+}
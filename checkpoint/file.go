@@ -0,0 +1,117 @@
+package checkpoint
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"farma/atomicfile"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileStore keeps every checkpoint as <Dir>/<id>.json and its visited
+// pages as <Dir>/<id>.visited, one per line.
+type FileStore struct {
+	Dir string
+}
+
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{Dir: dir}
+}
+
+func (s *FileStore) path(id string) string {
+	return filepath.Join(s.Dir, id+".json")
+}
+
+func (s *FileStore) visitedPath(id string) string {
+	return filepath.Join(s.Dir, id+".visited")
+}
+
+func (s *FileStore) Load(id string) (*State, error) {
+	b, err := os.ReadFile(s.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var state *State
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil, err
+	}
+
+	state.Visited, err = s.visited(id)
+
+	return state, err
+}
+
+// visited reads the visited pages of id. A line torn by a crash has no
+// newline and is left out, that page is just crawled again.
+func (s *FileStore) visited(id string) ([]string, error) {
+	file, err := os.Open(s.visitedPath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	visited := []string{}
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+		if href := strings.TrimSuffix(line, "\n"); href != "" {
+			visited = append(visited, href)
+		}
+	}
+
+	return visited, nil
+}
+
+// Visit appends hrefs to the visited file of id.
+func (s *FileStore) Visit(id string, hrefs []string) error {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(s.visitedPath(id), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.WriteString(strings.Join(hrefs, "\n") + "\n"); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func (s *FileStore) Clear(id string) error {
+	err := os.Remove(s.visitedPath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// Save replaces the checkpoint as a whole, a crash never leaves a torn one
+// behind.
+func (s *FileStore) Save(state *State) error {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(s.path(state.ID), b, 0644)
+}
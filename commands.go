@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"farma/canonical"
+	"farma/dict"
+	"farma/diff"
+	"farma/match"
+	"farma/mongodb"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// diffCommand prints what changed in a source between two crawl runs:
+// farma diff <source> <runA> <runB> [--format json|md]
+func diffCommand(args []string) {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	format := flags.String("format", "json", "report format: json or md")
+
+	if len(args) < 3 {
+		log.Fatal("usage: farma diff <source> <runA> <runB> [--format json|md]")
+	}
+	flags.Parse(args[3:])
+	source, runA, runB := args[0], args[1], args[2]
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	mClient := mongodb.NewMongoClient()
+	defer mClient.Close(ctx)
+
+	a, err := mClient.Snapshot(ctx, source, runA)
+	if err != nil {
+		log.Fatal(err)
+	}
+	b, err := mClient.Snapshot(ctx, source, runB)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report := diff.Compare(source, runA, runB, a, b)
+
+	switch *format {
+	case "json":
+		err = report.JSON(os.Stdout)
+	case "md":
+		err = report.Markdown(os.Stdout)
+	default:
+		log.Fatalf("unknown format `%s`", *format)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// matchCommand builds the cross-pharmacy matches of the given collections:
+// farma match build <collection>... [--threshold 0.6]
+// or prints the matches of a drug:
+// farma match --mnn <mnn>
+func matchCommand(args []string) {
+	if len(args) > 0 && args[0] == "build" {
+		matchBuild(args[1:])
+		return
+	}
+
+	flags := flag.NewFlagSet("match", flag.ExitOnError)
+	mnn := flags.String("mnn", "", "international nonproprietary name of the drug")
+	flags.Parse(args)
+
+	if *mnn == "" {
+		log.Fatal("usage: farma match build <collection>... | farma match --mnn <mnn>")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	mClient := mongodb.NewMongoClient()
+	defer mClient.Close(ctx)
+
+	matches, err := mClient.FindMatches(ctx, *mnn)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(matches); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func matchBuild(args []string) {
+	flags := flag.NewFlagSet("match build", flag.ExitOnError)
+	threshold := flags.Float64("threshold", match.DEFAULT_THRESHOLD, "minimal title similarity of fuzzy matches")
+
+	collections := []string{}
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		collections = append(collections, args[0])
+		args = args[1:]
+	}
+	flags.Parse(args)
+
+	if len(collections) == 0 {
+		log.Fatal("usage: farma match build <collection>... [--threshold 0.6]")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	mClient := mongodb.NewMongoClient()
+	defer mClient.Close(ctx)
+
+	meds := []*canonical.Medicament{}
+	for _, name := range collections {
+		loaded, err := mClient.Canonicals(ctx, name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		meds = append(meds, loaded...)
+	}
+
+	matches := match.Group(meds, *threshold)
+	if err := mClient.ReplaceMatches(ctx, matches); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Fprintf(os.Stderr, "%d products, %d matches\n", len(meds), len(matches))
+}
+
+// dictCommand reviews the values the normalization dictionaries could not
+// map:
+// farma dict unmapped <mnn|manufacturer> [--limit 50]
+// farma dict learn <mnn|manufacturer> <value> <id>
+// farma dict ignore <mnn|manufacturer> <value>
+func dictCommand(args []string) {
+	usage := "usage: farma dict unmapped|learn|ignore <mnn|manufacturer> ..."
+	if len(args) < 2 {
+		log.Fatal(usage)
+	}
+	action, kind := args[0], args[1]
+	if kind != dict.MNN && kind != dict.MANUFACTURER {
+		log.Fatalf("unknown dictionary `%s`", kind)
+	}
+	d := loadDict(kind)
+
+	switch {
+	case action == "unmapped":
+		flags := flag.NewFlagSet("dict unmapped", flag.ExitOnError)
+		limit := flags.Int("limit", 50, "how many values to show, the most frequent first")
+		flags.Parse(args[2:])
+
+		for i, u := range d.Unmapped() {
+			if i >= *limit {
+				break
+			}
+			fmt.Printf("%6d  %s  %s\n", u.Seen, u.Value, strings.Join(d.Suggest(u.Value), ", "))
+		}
+		return
+	case action == "learn" && len(args) == 4:
+		d.Learn(args[2], args[3])
+	case action == "ignore" && len(args) == 3:
+		d.Ignore(args[2])
+	default:
+		log.Fatal(usage)
+	}
+
+	if err := d.Save(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,313 @@
+package dict
+
+import (
+	"encoding/json"
+	"errors"
+	"farma/atomicfile"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MNN          string = "mnn"
+	MANUFACTURER string = "manufacturer"
+
+	SEED_DIR       string = "files/dict"
+	SUGGEST_PREFIX int    = 4
+
+	// A lock older than LOCK_STALE was left by a crashed process.
+	LOCK_TIMEOUT time.Duration = 10 * time.Second
+	LOCK_STALE   time.Duration = time.Minute
+)
+
+// Entry is a canonical name with the aliases it is known by.
+type Entry struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases"`
+}
+
+// learned is what a dictionary picked up while running: aliases added
+// through the CLI and values nothing maps to yet, with how often they were
+// seen.
+type learned struct {
+	Aliases  map[string]string `json:"aliases"`
+	Unmapped map[string]int    `json:"unmapped"`
+}
+
+// changes are what happened to a dictionary since it was loaded or saved.
+// Save merges them into the learned file as it is on disk, since other
+// crawls and CLI runs write it as well.
+type changes struct {
+	aliases map[string]string
+	ignored map[string]bool
+	seen    map[string]int
+}
+
+func newChanges() changes {
+	return changes{aliases: map[string]string{}, ignored: map[string]bool{}, seen: map[string]int{}}
+}
+
+// Dictionary maps raw names of one kind to canonical ids. Seed entries are
+// kept in the repo, learned ones next to the checkpoints.
+type Dictionary struct {
+	mu      sync.Mutex
+	kind    string
+	path    string
+	entries map[string]*Entry
+	aliases map[string]string
+	learned learned
+	changes changes
+}
+
+// Unmapped is a raw value without id.
+type Unmapped struct {
+	Value string `json:"value"`
+	Seen  int    `json:"seen"`
+}
+
+// Load reads the seed dictionary of kind from SEED_DIR and its learned part
+// from dir. Missing files give empty dictionaries.
+func Load(kind string, dir string) (*Dictionary, error) {
+	d := &Dictionary{
+		kind:    kind,
+		path:    filepath.Join(dir, kind+".json"),
+		entries: map[string]*Entry{},
+		aliases: map[string]string{},
+		changes: newChanges(),
+	}
+
+	var seed []*Entry
+	if err := readJSON(filepath.Join(SEED_DIR, kind+".json"), &seed); err != nil {
+		return nil, err
+	}
+	for _, e := range seed {
+		d.entries[e.ID] = e
+		d.aliases[Fold(e.ID)] = e.ID
+		d.aliases[Fold(e.Name)] = e.ID
+		for _, alias := range e.Aliases {
+			d.aliases[Fold(alias)] = e.ID
+		}
+	}
+
+	var err error
+	if d.learned, err = readLearned(d.path); err != nil {
+		return nil, err
+	}
+	for alias, id := range d.learned.Aliases {
+		d.aliases[alias] = id
+	}
+
+	return d, nil
+}
+
+func readLearned(path string) (learned, error) {
+	var l learned
+	err := readJSON(path, &l)
+	if l.Aliases == nil {
+		l.Aliases = map[string]string{}
+	}
+	if l.Unmapped == nil {
+		l.Unmapped = map[string]int{}
+	}
+
+	return l, err
+}
+
+func readJSON(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// Kind is what the dictionary names, MNN or MANUFACTURER.
+func (d *Dictionary) Kind() string {
+	return d.kind
+}
+
+// Lookup returns the canonical id of raw. Values without one are counted as
+// unmapped for review.
+func (d *Dictionary) Lookup(raw string) (string, bool) {
+	folded := Fold(raw)
+	if folded == "" {
+		return "", false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if id, ok := d.aliases[folded]; ok {
+		return id, true
+	}
+	d.learned.Unmapped[raw]++
+	d.changes.seen[raw]++
+
+	return "", false
+}
+
+// Learn makes raw an alias of id, which does not have to be a seed entry.
+func (d *Dictionary) Learn(raw string, id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	folded := Fold(raw)
+	d.aliases[folded] = id
+	d.learned.Aliases[folded] = id
+	d.changes.aliases[folded] = id
+
+	for value := range d.learned.Unmapped {
+		if Fold(value) == folded {
+			delete(d.learned.Unmapped, value)
+		}
+	}
+}
+
+// Ignore forgets an unmapped value until it is seen again.
+func (d *Dictionary) Ignore(raw string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.learned.Unmapped, raw)
+	delete(d.changes.seen, raw)
+	d.changes.ignored[raw] = true
+}
+
+// Unmapped lists values without id, the most frequent first.
+func (d *Dictionary) Unmapped() []*Unmapped {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	result := []*Unmapped{}
+	for value, seen := range d.learned.Unmapped {
+		result = append(result, &Unmapped{Value: value, Seen: seen})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Seen != result[j].Seen {
+			return result[i].Seen > result[j].Seen
+		}
+		return result[i].Value < result[j].Value
+	})
+
+	return result
+}
+
+// Suggest returns the ids with a folded name or alias starting like raw,
+// as hints for the review.
+func (d *Dictionary) Suggest(raw string) []string {
+	prefix := []rune(Fold(raw))
+	if len(prefix) > SUGGEST_PREFIX {
+		prefix = prefix[:SUGGEST_PREFIX]
+	}
+	if len(prefix) == 0 {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	seen := map[string]bool{}
+	result := []string{}
+	for alias, id := range d.aliases {
+		if strings.HasPrefix(alias, string(prefix)) && !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// Save merges what changed since the last save into the learned file,
+// re-read under a lock so that concurrent crawls and CLI runs keep each
+// other's updates. It is written through a temporary file so that a crash
+// never leaves it half written.
+func (d *Dictionary) Save() error {
+	if err := os.MkdirAll(filepath.Dir(d.path), 0755); err != nil {
+		return err
+	}
+
+	unlock, err := lock(d.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	l, err := readLearned(d.path)
+	if err != nil {
+		return err
+	}
+
+	for alias, id := range d.changes.aliases {
+		l.Aliases[alias] = id
+	}
+	for value := range d.changes.ignored {
+		delete(l.Unmapped, value)
+	}
+	for value, seen := range d.changes.seen {
+		l.Unmapped[value] += seen
+	}
+	for value := range l.Unmapped {
+		if _, ok := l.Aliases[Fold(value)]; ok {
+			delete(l.Unmapped, value)
+		}
+	}
+
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := atomicfile.WriteFile(d.path, data, 0644); err != nil {
+		return err
+	}
+
+	d.learned = l
+	d.changes = newChanges()
+	for alias, id := range l.Aliases {
+		d.aliases[alias] = id
+	}
+
+	return nil
+}
+
+// lock creates the lock file at path, waiting up to LOCK_TIMEOUT for
+// another process to remove it. The returned func removes it.
+func lock(path string) (func(), error) {
+	deadline := time.Now().Add(LOCK_TIMEOUT)
+
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > LOCK_STALE {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%s is locked", path)
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,49 @@
+package dict
+
+import (
+	"strings"
+	"unicode"
+)
+
+var translit = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e",
+	'ж': "zh", 'з': "z", 'и': "i", 'й': "i", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+	'ф': "f", 'х': "h", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "sch",
+	'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+}
+
+// LEGAL_FORMS are dropped from manufacturer names. They are compared after
+// transliteration, so "ООО" is "ooo".
+var LEGAL_FORMS = map[string]bool{
+	"ooo": true, "oao": true, "zao": true, "pao": true, "ao": true, "fgup": true,
+	"llc": true, "ltd": true, "inc": true, "gmbh": true, "ag": true, "co": true,
+	"kg": true, "sa": true, "spa": true, "plc": true, "corp": true,
+}
+
+// Fold brings a name to the form aliases are compared in: lower case,
+// transliterated to Latin, without punctuation, legal forms and extra
+// spaces. "ООО «Байер»" and "Bayer" still differ, that is what aliases
+// are for.
+func Fold(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case translit[r] != "" || r == 'ъ' || r == 'ь':
+			b.WriteString(translit[r])
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+		default:
+			b.WriteRune(' ')
+		}
+	}
+
+	words := []string{}
+	for _, word := range strings.Fields(b.String()) {
+		if !LEGAL_FORMS[word] {
+			words = append(words, word)
+		}
+	}
+
+	return strings.Join(words, " ")
+}
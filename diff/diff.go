@@ -0,0 +1,103 @@
+package diff
+
+import (
+	"reflect"
+	"sort"
+)
+
+// Item is one product of a crawl run.
+type Item struct {
+	Hash string
+	Data map[string]interface{}
+}
+
+// Snapshot is every product of a crawl run by its key.
+type Snapshot map[string]*Item
+
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+type Change struct {
+	Key    string         `json:"key"`
+	Fields []*FieldChange `json:"fields"`
+}
+
+type Report struct {
+	Source   string    `json:"source"`
+	RunA     string    `json:"run_a"`
+	RunB     string    `json:"run_b"`
+	Added    []string  `json:"added"`
+	Removed  []string  `json:"removed"`
+	Modified []*Change `json:"modified"`
+}
+
+// Compare lists the products that appeared in b, disappeared from a and
+// changed between them, with a change per top level field.
+func Compare(source string, runA string, runB string, a Snapshot, b Snapshot) *Report {
+	report := &Report{
+		Source:   source,
+		RunA:     runA,
+		RunB:     runB,
+		Added:    []string{},
+		Removed:  []string{},
+		Modified: []*Change{},
+	}
+
+	for key, itemB := range b {
+		itemA, ok := a[key]
+		if !ok {
+			report.Added = append(report.Added, key)
+			continue
+		}
+
+		if itemA.Hash != "" && itemA.Hash == itemB.Hash {
+			continue
+		}
+
+		if fields := compareFields(itemA.Data, itemB.Data); len(fields) > 0 {
+			report.Modified = append(report.Modified, &Change{Key: key, Fields: fields})
+		}
+	}
+
+	for key := range a {
+		if _, ok := b[key]; !ok {
+			report.Removed = append(report.Removed, key)
+		}
+	}
+
+	sort.Strings(report.Added)
+	sort.Strings(report.Removed)
+	sort.Slice(report.Modified, func(i, j int) bool {
+		return report.Modified[i].Key < report.Modified[j].Key
+	})
+
+	return report
+}
+
+func compareFields(a map[string]interface{}, b map[string]interface{}) []*FieldChange {
+	fields := map[string]bool{}
+	for field := range a {
+		fields[field] = true
+	}
+	for field := range b {
+		fields[field] = true
+	}
+
+	names := make([]string, 0, len(fields))
+	for field := range fields {
+		names = append(names, field)
+	}
+	sort.Strings(names)
+
+	changes := []*FieldChange{}
+	for _, field := range names {
+		if !reflect.DeepEqual(a[field], b[field]) {
+			changes = append(changes, &FieldChange{Field: field, Old: a[field], New: b[field]})
+		}
+	}
+
+	return changes
+}
@@ -0,0 +1,73 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// CELL_SIZE is how many characters of a value a table cell shows.
+const CELL_SIZE int = 200
+
+func (r *Report) JSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(r)
+}
+
+func (r *Report) Markdown(w io.Writer) error {
+	fmt.Fprintf(w, "# %s: %s → %s\n\n", r.Source, r.RunA, r.RunB)
+	fmt.Fprintf(w, "%d added, %d removed, %d modified\n", len(r.Added), len(r.Removed), len(r.Modified))
+
+	if len(r.Added) > 0 {
+		fmt.Fprintf(w, "\n## Added\n\n")
+		for _, key := range r.Added {
+			fmt.Fprintf(w, "- `%s`\n", key)
+		}
+	}
+
+	if len(r.Removed) > 0 {
+		fmt.Fprintf(w, "\n## Removed\n\n")
+		for _, key := range r.Removed {
+			fmt.Fprintf(w, "- `%s`\n", key)
+		}
+	}
+
+	if len(r.Modified) > 0 {
+		fmt.Fprintf(w, "\n## Modified\n")
+		for _, change := range r.Modified {
+			fmt.Fprintf(w, "\n### `%s`\n\n| field | old | new |\n| --- | --- | --- |\n", change.Key)
+			for _, field := range change.Fields {
+				fmt.Fprintf(w, "| %s | %s | %s |\n", escape(field.Field), cell(field.Old), cell(field.New))
+			}
+		}
+	}
+
+	return nil
+}
+
+// escape keeps pipes of a value from splitting the table row.
+func escape(s string) string {
+	return strings.ReplaceAll(s, "|", "\\|")
+}
+
+// cell renders a field value as compact JSON fit for a table cell.
+func cell(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return escape(fmt.Sprint(v))
+	}
+
+	s := string(b)
+	if runes := []rune(s); len(runes) > CELL_SIZE {
+		s = string(runes[:CELL_SIZE]) + "…"
+	}
+
+	return "`" + escape(s) + "`"
+}
@@ -0,0 +1,126 @@
+package dosage
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Dosage is what a product title tells about the drug amount and package.
+type Dosage struct {
+	Value float64 `json:"value" bson:"value"`
+	Unit  string  `json:"unit" bson:"unit"`
+	Form  string  `json:"form" bson:"form"`
+	Count int     `json:"count" bson:"count"`
+}
+
+// FORMS maps title abbreviations to release forms. A word takes the form
+// of the first prefix it starts with, so longer prefixes go first.
+var FORMS = []struct {
+	Prefix string
+	Form   string
+}{
+	{"табл", "таблетки"},
+	{"таб", "таблетки"},
+	{"капс", "капсулы"},
+	{"капл", "капли"},
+	{"р-р", "раствор"},
+	{"раствор", "раствор"},
+	{"амп", "ампулы"},
+	{"сусп", "суспензия"},
+	{"сироп", "сироп"},
+	{"мазь", "мазь"},
+	{"крем", "крем"},
+	{"гель", "гель"},
+	{"спрей", "спрей"},
+	{"аэроз", "аэрозоль"},
+	{"пор", "порошок"},
+	{"гран", "гранулы"},
+	{"супп", "суппозитории"},
+	{"свеч", "суппозитории"},
+	{"драже", "драже"},
+	{"пастил", "пастилки"},
+	{"лиоф", "лиофилизат"},
+	{"конц", "концентрат"},
+	{"эмул", "эмульсия"},
+	{"пласт", "пластырь"},
+}
+
+var (
+	valueRe = regexp.MustCompile(`(?i)(\d+(?:[.,]\d+)?)\s*(мкг|мг|мл|г|л|ме|ед|%)(?:\s*/\s*(мкг|мг|мл|г|доз[аы]?))?(?:[^\p{L}]|$)`)
+	countRe = regexp.MustCompile(`(?:^|[^\p{L}])(?:№|N|[xх])\s*(\d+)|(\d+)\s*шт`)
+)
+
+// Parse reads dosage, form and pack count of a title like
+// "Нурофен таб. п/о 200мг №20". Whatever is not found stays zero.
+func Parse(title string) *Dosage {
+	d := &Dosage{}
+	// The first word is the brand, which may well start like a form does,
+	// e.g. "Амприлан" or "Капсикам".
+	if words := strings.Fields(title); len(words) > 1 {
+		d.Form = Form(strings.Join(words[1:], " "))
+	}
+
+	if m := valueRe.FindStringSubmatch(title); m != nil {
+		d.Value, _ = strconv.ParseFloat(strings.Replace(m[1], ",", ".", 1), 64)
+		d.Unit = unit(m[2])
+		if m[3] != "" {
+			d.Unit += "/" + unit(m[3])
+		}
+	}
+
+	if m := countRe.FindStringSubmatch(title); m != nil {
+		n := m[1]
+		if n == "" {
+			n = m[2]
+		}
+		d.Count, _ = strconv.Atoi(n)
+	}
+
+	return d
+}
+
+// Form returns the release form named by the first word of s that has one.
+func Form(s string) string {
+	for _, word := range strings.Fields(strings.ToLower(s)) {
+		for _, f := range FORMS {
+			if strings.HasPrefix(word, f.Prefix) {
+				return f.Form
+			}
+		}
+	}
+
+	return ""
+}
+
+func unit(u string) string {
+	u = strings.ToLower(u)
+
+	switch {
+	case u == "ме" || u == "ед":
+		return strings.ToUpper(u)
+	case strings.HasPrefix(u, "доз"):
+		return "доза"
+	}
+
+	return u
+}
+
+// Empty tells whether nothing was parsed.
+func (d *Dosage) Empty() bool {
+	return d.Value == 0 && d.Form == "" && d.Count == 0
+}
+
+// String is the dosage amount, e.g. "200 мг", or "" if there is none.
+func (d *Dosage) String() string {
+	if d.Value == 0 {
+		return ""
+	}
+
+	value := strconv.FormatFloat(d.Value, 'f', -1, 64)
+	if d.Unit == "%" {
+		return value + d.Unit
+	}
+
+	return value + " " + d.Unit
+}
@@ -0,0 +1,47 @@
+package dosage
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		title string
+		want  Dosage
+	}{
+		{"Нурофен таб. п/о 200мг №20", Dosage{Value: 200, Unit: "мг", Form: "таблетки", Count: 20}},
+		{"Амприлан таб. 5мг №30", Dosage{Value: 5, Unit: "мг", Form: "таблетки", Count: 30}},
+		{"Грандаксин таб. 50мг №60", Dosage{Value: 50, Unit: "мг", Form: "таблетки", Count: 60}},
+		{"Капсикам мазь 50г", Dosage{Value: 50, Unit: "г", Form: "мазь"}},
+		{"Порталак сироп 667мг/мл 500мл", Dosage{Value: 667, Unit: "мг/мл", Form: "сироп"}},
+		{"Амоксиклав пор. д/сусп. 250мг+62,5мг/5мл", Dosage{Value: 250, Unit: "мг", Form: "порошок"}},
+		{"Називин капли наз. 0,05% 10мл", Dosage{Value: 0.05, Unit: "%", Form: "капли"}},
+		{"Аква Марис спрей наз. 30мл", Dosage{Value: 30, Unit: "мл", Form: "спрей"}},
+		{"Аквадетрим 15000МЕ/мл 10мл", Dosage{Value: 15000, Unit: "МЕ/мл"}},
+		{"Арбидол капс. 100мг х10", Dosage{Value: 100, Unit: "мг", Form: "капсулы", Count: 10}},
+		{"Парацетамол 10 шт", Dosage{Count: 10}},
+		{"Капсикам", Dosage{}},
+	}
+
+	for _, tt := range tests {
+		if got := Parse(tt.title); *got != tt.want {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.title, *got, tt.want)
+		}
+	}
+}
+
+func TestForm(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"таблетки", "таблетки"},
+		{"Таблетки, покрытые оболочкой", "таблетки"},
+		{"р-р д/ин.", "раствор"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := Form(tt.s); got != tt.want {
+			t.Errorf("Form(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package egress
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const DEFAULT_ECHO_URL string = "https://api.myip.com/"
+
+// Result is what the echo endpoint sees of the crawler.
+type Result struct {
+	IP      string `json:"ip"`
+	Country string `json:"country"`
+	CC      string `json:"cc"`
+}
+
+// Policy is where the crawler may come from. Allow, if not empty, lists
+// the only accepted country codes, Deny the refused ones. ExpectIPs, if not
+// empty, are the only accepted addresses. Skip trusts the egress without
+// asking, for offline runs. Every re-verifies during the crawl.
+type Policy struct {
+	EchoURL   string
+	Allow     []string
+	Deny      []string
+	ExpectIPs []string
+	Skip      bool
+	Every     time.Duration
+}
+
+var DefaultPolicy = Policy{
+	EchoURL: DEFAULT_ECHO_URL,
+	Deny:    []string{"RU"},
+}
+
+func list(raw string) []string {
+	return strings.FieldsFunc(raw, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
+
+// PolicyFromEnv reads EGRESS_ECHO_URL, EGRESS_ALLOW, EGRESS_DENY and
+// EGRESS_EXPECT_IP, comma separated, EGRESS_SKIP and EGRESS_EVERY, e.g.
+// "10m", on top of DefaultPolicy. An empty EGRESS_DENY keeps the default,
+// "-" denies nothing.
+func PolicyFromEnv() (Policy, error) {
+	policy := DefaultPolicy
+
+	if echoURL := os.Getenv("EGRESS_ECHO_URL"); echoURL != "" {
+		policy.EchoURL = echoURL
+	}
+	if allow := os.Getenv("EGRESS_ALLOW"); allow != "" {
+		policy.Allow = list(allow)
+	}
+	if deny := os.Getenv("EGRESS_DENY"); deny == "-" {
+		policy.Deny = nil
+	} else if deny != "" {
+		policy.Deny = list(deny)
+	}
+	policy.ExpectIPs = list(os.Getenv("EGRESS_EXPECT_IP"))
+
+	switch os.Getenv("EGRESS_SKIP") {
+	case "", "0", "false":
+	default:
+		policy.Skip = true
+	}
+
+	if every := os.Getenv("EGRESS_EVERY"); every != "" {
+		d, err := time.ParseDuration(every)
+		if err != nil {
+			return policy, fmt.Errorf("bad EGRESS_EVERY `%s`: %w", every, err)
+		}
+		policy.Every = d
+	}
+
+	return policy, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllowedCountry tells whether the policy accepts the country code.
+func (p Policy) AllowedCountry(cc string) bool {
+	if len(p.Allow) > 0 && !contains(p.Allow, cc) {
+		return false
+	}
+
+	return !contains(p.Deny, cc)
+}
+
+// Check returns why the result breaks the policy, or nil.
+func (p Policy) Check(r *Result) error {
+	if !p.AllowedCountry(r.CC) {
+		return &Error{Result: r, Reason: fmt.Sprintf("country %s is not allowed", r.CC)}
+	}
+	if len(p.ExpectIPs) > 0 && !contains(p.ExpectIPs, r.IP) {
+		return &Error{Result: r, Reason: fmt.Sprintf("ip %s is not expected", r.IP)}
+	}
+
+	return nil
+}
+
+// Error is an egress breaking the policy.
+type Error struct {
+	Result *Result
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return "egress rejected: " + e.Reason
+}
+
+// Echo asks the echo endpoint at url through rt.
+func Echo(ctx context.Context, rt http.RoundTripper, url string) (*Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("echo status %d", resp.StatusCode)
+	}
+
+	var result *Result
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Verifier checks the egress of a client against a policy.
+type Verifier struct {
+	policy Policy
+	client *http.Client
+}
+
+func New(policy Policy, client *http.Client) *Verifier {
+	if policy.EchoURL == "" {
+		policy.EchoURL = DEFAULT_ECHO_URL
+	}
+
+	return &Verifier{policy: policy, client: client}
+}
+
+// Verify asks the echo endpoint through the client. With Skip it returns a
+// nil result and no error.
+func (v *Verifier) Verify(ctx context.Context) (*Result, error) {
+	if v.policy.Skip {
+		return nil, nil
+	}
+
+	result, err := Echo(ctx, roundTripper{v.client}, v.policy.EchoURL)
+	if err != nil {
+		return nil, fmt.Errorf("egress not verified: %w", err)
+	}
+
+	return result, v.policy.Check(result)
+}
+
+// Watch verifies every Every until ctx is done and calls fail with the
+// first policy violation. Failed checks are logged and tried again at the
+// next tick. It returns right away without Every or with Skip.
+func (v *Verifier) Watch(ctx context.Context, fail func(error)) {
+	if v.policy.Skip || v.policy.Every <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(v.policy.Every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			result, err := v.Verify(ctx)
+			var violation *Error
+			switch {
+			case ctx.Err() != nil:
+				return
+			case errors.As(err, &violation):
+				fail(err)
+				return
+			case err != nil:
+				log.Printf("egress check failed, retrying in %s: %v", v.policy.Every, err)
+				continue
+			}
+			log.Printf("egress still OK: %+v", *result)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// roundTripper sends through a client, with its middlewares and jar.
+type roundTripper struct {
+	client *http.Client
+}
+
+func (rt roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	return rt.client.Do(r)
+}
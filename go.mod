@@ -4,6 +4,7 @@ go 1.16
 
 require (
 	github.com/PuerkitoBio/goquery v1.6.1
+	github.com/andybalholm/cascadia v1.1.0
 	github.com/itchyny/gojq v0.12.3
 	github.com/joho/godotenv v1.3.0
 	go.mongodb.org/mongo-driver v1.5.2
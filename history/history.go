@@ -0,0 +1,55 @@
+package history
+
+import (
+	"context"
+	"time"
+)
+
+// Observation is the price of one product seen by one crawl. InStock is nil
+// for sources that do not tell.
+type Observation struct {
+	Source     string    `json:"source" bson:"source"`
+	Key        string    `json:"key" bson:"key"`
+	RunID      string    `json:"run_id" bson:"run_id"`
+	Price      float64   `json:"price" bson:"price"`
+	Currency   string    `json:"currency" bson:"currency"`
+	InStock    *bool     `json:"in_stock,omitempty" bson:"in_stock,omitempty"`
+	ObservedAt time.Time `json:"observed_at" bson:"observed_at"`
+}
+
+// Changed reports whether o differs from the previous observation prev.
+func (o *Observation) Changed(prev *Observation) bool {
+	return prev == nil ||
+		prev.Price != o.Price ||
+		prev.Currency != o.Currency ||
+		!sameStock(prev.InStock, o.InStock)
+}
+
+func sameStock(a *bool, b *bool) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return *a == *b
+}
+
+// Priced records know their current price. Source, RunID and ObservedAt of the
+// returned observation are filled in by the recorder. Records without a
+// price return nil, a missing price is no change.
+type Priced interface {
+	Observation() *Observation
+}
+
+type Recorder interface {
+	// Record stores o unless the product price did not change.
+	Record(ctx context.Context, o *Observation) error
+}
+
+// Move is the price change of a product between two runs.
+type Move struct {
+	Key       string  `json:"key" bson:"key"`
+	From      float64 `json:"from" bson:"from"`
+	To        float64 `json:"to" bson:"to"`
+	Change    float64 `json:"change" bson:"change"`
+	ChangePct float64 `json:"change_pct" bson:"change_pct"`
+}
@@ -0,0 +1,279 @@
+package httpcache
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"farma/atomicfile"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	DEFAULT_TTL time.Duration = 24 * time.Hour
+	FROM_CACHE  string        = "X-From-Cache"
+)
+
+// ErrNotCached is the answer to requests missing from the cache in the
+// cache only mode.
+var ErrNotCached = errors.New("not cached")
+
+// Config of the cache. Entries older than TTL are revalidated with their
+// ETag or Last-Modified, or fetched again without them. Only answers every
+// request from disk, however old, and never goes to the network.
+type Config struct {
+	Dir  string
+	TTL  time.Duration
+	Only bool
+}
+
+// Cache keeps successful responses under Dir, gzipped, addressed by the
+// hash of method, URL and body. It is an http.RoundTripper in front of
+// next.
+type Cache struct {
+	config Config
+	next   http.RoundTripper
+}
+
+// entry is the head of a cached response, the body follows it in the file.
+type entry struct {
+	Method   string      `json:"method"`
+	URL      string      `json:"url"`
+	Status   int         `json:"status"`
+	Header   http.Header `json:"header"`
+	StoredAt time.Time   `json:"stored_at"`
+}
+
+func New(config Config, next http.RoundTripper) *Cache {
+	if config.TTL == 0 {
+		config.TTL = DEFAULT_TTL
+	}
+
+	return &Cache{config: config, next: next}
+}
+
+// body reads the request body without consuming it.
+func body(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	if r.GetBody == nil {
+		return nil, errors.New("request body can not be read twice")
+	}
+
+	rc, err := r.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return io.ReadAll(rc)
+}
+
+func (c *Cache) path(r *http.Request, reqBody []byte) string {
+	bodyHash := sha256.Sum256(reqBody)
+	key := sha256.Sum256([]byte(r.Method + "\n" + r.URL.String() + "\n" + hex.EncodeToString(bodyHash[:])))
+	name := hex.EncodeToString(key[:])
+
+	return filepath.Join(c.config.Dir, name[:2], name+".gz")
+}
+
+// head reads the head of an entry, leaving the reader at the start of
+// the body. The caller closes the file.
+func (c *Cache) head(path string) (*os.File, *entry, *bufio.Reader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, nil, err
+	}
+
+	reader := bufio.NewReader(gz)
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		file.Close()
+		return nil, nil, nil, err
+	}
+
+	var e *entry
+	if err := json.Unmarshal(line, &e); err != nil {
+		file.Close()
+		return nil, nil, nil, err
+	}
+
+	return file, e, reader, nil
+}
+
+func (c *Cache) load(path string) (*entry, []byte, error) {
+	file, e, reader, err := c.head(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	b, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return e, b, nil
+}
+
+// store replaces the entry at path as a whole, a crash never leaves a torn
+// one behind.
+func (c *Cache) store(path string, e *entry, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	head, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(head)
+	gz.Write([]byte("\n"))
+	gz.Write(b)
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(path, buf.Bytes(), 0644)
+}
+
+func (c *Cache) fresh(e *entry) bool {
+	return c.config.Only || c.config.TTL < 0 || time.Since(e.StoredAt) < c.config.TTL
+}
+
+// Fresh tells whether the request is answered from disk without asking the
+// site at all.
+func (c *Cache) Fresh(r *http.Request) bool {
+	if c.config.Only {
+		return true
+	}
+
+	reqBody, err := body(r)
+	if err != nil {
+		return false
+	}
+
+	// Only the head is read, the body is left to RoundTrip.
+	file, e, _, err := c.head(c.path(r, reqBody))
+	if err != nil {
+		return false
+	}
+	file.Close()
+
+	return c.fresh(e)
+}
+
+func response(r *http.Request, e *entry, b []byte) *http.Response {
+	header := e.Header.Clone()
+	header.Set(FROM_CACHE, "1")
+
+	return &http.Response{
+		Status:        strconv.Itoa(e.Status) + " " + http.StatusText(e.Status),
+		StatusCode:    e.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(b)),
+		ContentLength: int64(len(b)),
+		Request:       r,
+	}
+}
+
+func (c *Cache) RoundTrip(r *http.Request) (*http.Response, error) {
+	reqBody, err := body(r)
+	if err != nil {
+		return c.next.RoundTrip(r)
+	}
+	path := c.path(r, reqBody)
+
+	// A broken entry is as good as a missing one, it gets replaced.
+	cached, cachedBody, _ := c.load(path)
+
+	switch {
+	case cached != nil && c.fresh(cached):
+		return response(r, cached, cachedBody), nil
+	case c.config.Only:
+		return nil, fmt.Errorf("%s %s: %w", r.Method, r.URL, ErrNotCached)
+	}
+
+	req := r
+	if cached != nil {
+		validators := map[string]string{
+			"If-None-Match":     cached.Header.Get("ETag"),
+			"If-Modified-Since": cached.Header.Get("Last-Modified"),
+		}
+		for name, value := range validators {
+			if value == "" {
+				continue
+			}
+			if req == r {
+				req = r.Clone(r.Context())
+				if reqBody != nil {
+					req.Body = io.NopCloser(bytes.NewReader(reqBody))
+				}
+			}
+			req.Header.Set(name, value)
+		}
+	}
+
+	resp, err := c.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		resp.Body.Close()
+		cached.StoredAt = time.Now()
+		if err := c.store(path, cached, cachedBody); err != nil {
+			log.Printf("cache entry of %s not refreshed: %v", r.URL, err)
+		}
+		return response(r, cached, cachedBody), nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	e := &entry{
+		Method:   r.Method,
+		URL:      r.URL.String(),
+		Status:   resp.StatusCode,
+		Header:   resp.Header,
+		StoredAt: time.Now(),
+	}
+	if err := c.store(path, e, b); err != nil {
+		log.Printf("response of %s not cached: %v", r.URL, err)
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+	resp.ContentLength = int64(len(b))
+
+	return resp, nil
+}
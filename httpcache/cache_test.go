@@ -0,0 +1,219 @@
+package httpcache
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// origin counts the requests reaching it and the ones it answers with 304.
+// It echoes method, path and body. Responses under /tagged/ carry ETag "v1".
+type origin struct {
+	*httptest.Server
+	hits        int32
+	notModified int32
+}
+
+func newOrigin(t *testing.T) *origin {
+	o := &origin{}
+	o.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&o.hits, 1)
+		if strings.HasPrefix(r.URL.Path, "/tagged/") {
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				atomic.AddInt32(&o.notModified, 1)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+
+		b, _ := io.ReadAll(r.Body)
+		w.Write([]byte(r.Method + " " + r.URL.Path + " " + string(b)))
+	}))
+	t.Cleanup(o.Close)
+
+	return o
+}
+
+// offline fails the test for any request that goes out.
+type offline struct {
+	t *testing.T
+}
+
+func (o offline) RoundTrip(r *http.Request) (*http.Response, error) {
+	o.t.Errorf("%s %s went out", r.Method, r.URL)
+	return nil, errors.New("offline")
+}
+
+type result struct {
+	body      string
+	fromCache bool
+}
+
+func fetch(t *testing.T, c *Cache, method string, url string, body string) (result, error) {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := c.RoundTrip(req)
+	if err != nil {
+		return result{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s %s: status %d", method, url, resp.StatusCode)
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return result{string(b), resp.Header.Get(FROM_CACHE) != ""}, nil
+}
+
+func mustFetch(t *testing.T, c *Cache, method string, url string, body string) result {
+	r, err := fetch(t, c, method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
+
+// backdate makes the entry of a GET of url look stored age ago.
+func backdate(t *testing.T, c *Cache, url string, age time.Duration) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := c.path(req, nil)
+
+	e, b, err := c.load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.StoredAt = time.Now().Add(-age)
+	if err := c.store(path, e, b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	tests := []struct {
+		name string
+		ttl  time.Duration
+		age  time.Duration
+		want result
+		hits int32
+	}{
+		{"fresh", time.Hour, 0, result{"GET /a ", true}, 1},
+		{"expired", time.Hour, 2 * time.Hour, result{"GET /a ", false}, 2},
+		{"never expires", -1, 1000 * time.Hour, result{"GET /a ", true}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newOrigin(t)
+			c := New(Config{Dir: t.TempDir(), TTL: tt.ttl}, http.DefaultTransport)
+
+			if got := mustFetch(t, c, "GET", o.URL+"/a", ""); got.fromCache {
+				t.Fatal("first fetch from cache")
+			}
+			backdate(t, c, o.URL+"/a", tt.age)
+
+			if got := mustFetch(t, c, "GET", o.URL+"/a", ""); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			if hits := atomic.LoadInt32(&o.hits); hits != tt.hits {
+				t.Errorf("%d requests went out, want %d", hits, tt.hits)
+			}
+		})
+	}
+}
+
+func TestRevalidate(t *testing.T) {
+	o := newOrigin(t)
+	c := New(Config{Dir: t.TempDir(), TTL: time.Hour}, http.DefaultTransport)
+	url := o.URL + "/tagged/a"
+
+	mustFetch(t, c, "GET", url, "")
+	backdate(t, c, url, 2*time.Hour)
+
+	want := result{"GET /tagged/a ", true}
+	if got := mustFetch(t, c, "GET", url, ""); got != want {
+		t.Errorf("revalidated: got %+v, want %+v", got, want)
+	}
+	if n := atomic.LoadInt32(&o.notModified); n != 1 {
+		t.Errorf("%d answers not modified, want 1", n)
+	}
+
+	// The 304 refreshed the entry, it is fresh again.
+	if got := mustFetch(t, c, "GET", url, ""); got != want {
+		t.Errorf("refreshed: got %+v, want %+v", got, want)
+	}
+	if hits := atomic.LoadInt32(&o.hits); hits != 2 {
+		t.Errorf("%d requests went out, want 2", hits)
+	}
+}
+
+func TestBodyKey(t *testing.T) {
+	o := newOrigin(t)
+	c := New(Config{Dir: t.TempDir(), TTL: time.Hour}, http.DefaultTransport)
+
+	requests := []struct {
+		method string
+		body   string
+		want   result
+	}{
+		{"POST", `{"page":1}`, result{`POST /q {"page":1}`, false}},
+		{"POST", `{"page":2}`, result{`POST /q {"page":2}`, false}},
+		{"POST", `{"page":1}`, result{`POST /q {"page":1}`, true}},
+		{"PUT", `{"page":1}`, result{`PUT /q {"page":1}`, false}},
+		{"POST", `{"page":2}`, result{`POST /q {"page":2}`, true}},
+	}
+
+	for i, r := range requests {
+		if got := mustFetch(t, c, r.method, o.URL+"/q", r.body); got != r.want {
+			t.Errorf("request %d: got %+v, want %+v", i, got, r.want)
+		}
+	}
+	if hits := atomic.LoadInt32(&o.hits); hits != 3 {
+		t.Errorf("%d requests went out, want 3", hits)
+	}
+}
+
+func TestCacheOnly(t *testing.T) {
+	o := newOrigin(t)
+	dir := t.TempDir()
+
+	mustFetch(t, New(Config{Dir: dir}, http.DefaultTransport), "GET", o.URL+"/a", "")
+	only := New(Config{Dir: dir, Only: true}, offline{t})
+	backdate(t, only, o.URL+"/a", 1000*time.Hour)
+
+	want := result{"GET /a ", true}
+	if got := mustFetch(t, only, "GET", o.URL+"/a", ""); got != want {
+		t.Errorf("cached: got %+v, want %+v", got, want)
+	}
+
+	req, err := http.NewRequest("GET", o.URL+"/b", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !only.Fresh(req) {
+		t.Error("a miss waits for the limiter, though nothing goes out")
+	}
+	if _, err := fetch(t, only, "GET", o.URL+"/b", ""); !errors.Is(err, ErrNotCached) {
+		t.Errorf("miss: error %v, want %v", err, ErrNotCached)
+	}
+}
@@ -1,30 +1,181 @@
 package jq
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"os"
+	"strings"
 
 	"github.com/itchyny/gojq"
 )
 
-func Transform(data interface{}, JQScript string) (interface{}, error) {
-	query, err := gojq.Parse(JQScript)
+// LIBRARY_DIR holds the modules programs can `import` or `include`.
+const LIBRARY_DIR string = "files/jq"
+
+// Error is a failure of a named program.
+type Error struct {
+	Program string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("jq `%s`: %v", e.Program, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+type options struct {
+	variables []string
+	library   []string
+	compiler  []gojq.CompilerOption
+}
+
+type Option func(*options)
+
+// WithVariables declares `$name` variables, their values are passed to
+// Run, RunAll and Stream in the same order.
+func WithVariables(names ...string) Option {
+	return func(o *options) {
+		o.variables = append(o.variables, names...)
+	}
+}
+
+// WithLibrary adds directories to look modules up in, LIBRARY_DIR is
+// always there.
+func WithLibrary(dirs ...string) Option {
+	return func(o *options) {
+		o.library = append(o.library, dirs...)
+	}
+}
+
+// WithFunction adds a Go function callable from the program, see
+// gojq.WithFunction.
+func WithFunction(name string, minArity int, maxArity int, fn func(interface{}, []interface{}) interface{}) Option {
+	return func(o *options) {
+		o.compiler = append(o.compiler, gojq.WithFunction(name, minArity, maxArity, fn))
+	}
+}
+
+// Program is a jq script compiled once and run many times.
+type Program struct {
+	name string
+	code *gojq.Code
+}
+
+// Compile compiles script. Name is only used in errors.
+func Compile(name string, script string, opts ...Option) (*Program, error) {
+	o := &options{library: []string{LIBRARY_DIR}}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	query, err := gojq.Parse(script)
 	if err != nil {
-		return nil, err
+		return nil, &Error{Program: name, Err: err}
 	}
 
-	code, err := gojq.Compile(query)
+	compilerOptions := append([]gojq.CompilerOption{
+		gojq.WithModuleLoader(gojq.NewModuleLoader(o.library)),
+		gojq.WithVariables(o.variables),
+		gojq.WithFunction("trim", 0, 0, trim),
+	}, o.compiler...)
+
+	code, err := gojq.Compile(query, compilerOptions...)
+	if err != nil {
+		return nil, &Error{Program: name, Err: err}
+	}
+
+	return &Program{name: name, code: code}, nil
+}
+
+// CompileFile compiles the script at path.
+func CompileFile(path string, opts ...Option) (*Program, error) {
+	script, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	iter := code.Run(data)
-	transformed, ok := iter.Next()
-	if !ok {
-		return nil, errors.New("Empty")
+	return Compile(path, string(script), opts...)
+}
+
+func (p *Program) Name() string {
+	return p.name
+}
+
+// Stream calls fn with every output of the program until the outputs end,
+// the program fails, fn returns an error or ctx is done.
+func (p *Program) Stream(ctx context.Context, data interface{}, fn func(interface{}) error, values ...interface{}) error {
+	iter := p.code.RunWithContext(ctx, data, values...)
+
+	for i := 0; ; i++ {
+		out, ok := iter.Next()
+		if !ok {
+			return nil
+		}
+
+		if err, ok := out.(error); ok {
+			return &Error{Program: p.name, Err: fmt.Errorf("output %d: %w", i, err)}
+		}
+
+		if err := fn(out); err != nil {
+			return err
+		}
+	}
+}
+
+// RunAll returns every output of the program.
+func (p *Program) RunAll(data interface{}, values ...interface{}) ([]interface{}, error) {
+	outs := []interface{}{}
+
+	err := p.Stream(context.Background(), data, func(out interface{}) error {
+		outs = append(outs, out)
+		return nil
+	}, values...)
+
+	return outs, err
+}
+
+var errStop = errors.New("stop")
+
+// Run returns the first output of the program.
+func (p *Program) Run(data interface{}, values ...interface{}) (interface{}, error) {
+	var first interface{}
+	found := false
+
+	err := p.Stream(context.Background(), data, func(out interface{}) error {
+		first, found = out, true
+		return errStop
+	}, values...)
+	if err != nil && err != errStop {
+		return nil, err
+	}
+
+	if !found {
+		return nil, &Error{Program: p.name, Err: errors.New("no output")}
 	}
-	if err, ok := transformed.(error); ok {
+
+	return first, nil
+}
+
+// Transform compiles JQScript and returns its first output. It compiles on
+// every call, so anything run more than once should be a Program.
+func Transform(data interface{}, JQScript string) (interface{}, error) {
+	program, err := Compile("inline", JQScript)
+	if err != nil {
 		return nil, err
 	}
 
-	return transformed, nil
+	return program.Run(data)
+}
+
+func trim(v interface{}, _ []interface{}) interface{} {
+	s, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("trim: %v is not a string", v)
+	}
+
+	return strings.TrimSpace(s)
 }
@@ -1,82 +1,385 @@
 package main
 
 import (
+	"context"
 	"errors"
-	"farma/gz"
-	"farma/hp"
-	"farma/oz"
+	"farma/checkpoint"
+	"farma/dict"
+	"farma/egress"
+	"farma/httpcache"
+	"farma/mongodb"
 	"farma/parser"
+	"farma/proxypool"
+	"farma/sink"
+	_ "farma/sources"
+	"flag"
 	"fmt"
+	"io/fs"
 	"log"
-	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
-	"golang.org/x/net/proxy"
 )
 
-func setUpProxy() {
-	proxyURL := os.Getenv("PROXY_URL")
-	proxyUsername := os.Getenv("PROXY_USERNAME")
-	proxyPass := os.Getenv("PROXY_PASS")
+// proxyURLs reads PROXY_LIST, comma or newline separated proxy URLs, and
+// PROXY_FILE with one URL per line. PROXY_URL with PROXY_USERNAME and
+// PROXY_PASS is a single SOCKS5 proxy. <SOURCE>_PROXY_LIST replaces all of
+// them for one source.
+func proxyURLs(source string) []string {
+	split := func(list string) []string {
+		return strings.FieldsFunc(list, func(r rune) bool {
+			return r == ',' || r == '\n'
+		})
+	}
+
+	if own := os.Getenv(strings.ToUpper(source) + "_PROXY_LIST"); own != "" {
+		return split(own)
+	}
+
+	urls := split(os.Getenv("PROXY_LIST"))
+
+	if path := os.Getenv("PROXY_FILE"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				urls = append(urls, line)
+			}
+		}
+	}
 
-	baseDialer := &net.Dialer{
-		Timeout:   30 * time.Second,
-		KeepAlive: 30 * time.Second,
+	if host := os.Getenv("PROXY_URL"); host != "" {
+		u := &url.URL{Scheme: "socks5", Host: host}
+		if user := os.Getenv("PROXY_USERNAME"); user != "" {
+			u.User = url.UserPassword(user, os.Getenv("PROXY_PASS"))
+		}
+		urls = append(urls, u.String())
 	}
 
-	dialer, err := proxy.SOCKS5(
-		"tcp",
-		proxyURL,
-		&proxy.Auth{User: proxyUsername, Password: proxyPass},
-		baseDialer,
-	)
+	return urls
+}
+
+// setUpClient gives the source its own client and egress verifier. The
+// client goes through a proxy pool, which checks its proxies until ctx is
+// done, or straight out if no proxy is configured. With a cache config the
+// responses are cached on disk, in the cache only mode nothing goes out.
+func setUpClient(ctx context.Context, config *parser.Config, cacheConfig *httpcache.Config) *proxypool.Pool {
+	policy, err := egress.PolicyFromEnv()
 	if err != nil {
 		log.Fatal(err)
 	}
-	contextDialer, ok := dialer.(proxy.ContextDialer)
-	if !ok {
-		log.Fatal(errors.New("fails contextDialer init"))
+	if cacheConfig != nil && cacheConfig.Only {
+		policy.Skip = true
+	}
+
+	var pool *proxypool.Pool
+	var base http.RoundTripper
+	if cacheConfig != nil && cacheConfig.Only {
+		log.Printf("cache only, nothing goes out")
+	} else if urls := proxyURLs(config.Source); len(urls) > 0 {
+		poolConfig := proxypool.DefaultConfig
+		poolConfig.Transport = parser.NewTransport(config.HTTP)
+		if !policy.Skip {
+			poolConfig.Allow = policy.AllowedCountry
+		}
+		if checkURL := os.Getenv("PROXY_CHECK_URL"); checkURL != "" {
+			poolConfig.CheckURL = checkURL
+		} else {
+			poolConfig.CheckURL = policy.EchoURL
+		}
+		if rotation := os.Getenv("PROXY_ROTATION"); rotation != "" {
+			poolConfig.Rotation = rotation
+		}
+
+		pool, err = proxypool.New(urls, poolConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// Health checks keep dead proxies out whatever the egress policy,
+		// skipping it only lets any country through.
+		pool.Check(ctx)
+		if pool.Available() == 0 {
+			log.Fatal("no healthy proxy")
+		}
+		go pool.Run(ctx)
+
+		base = pool
+	} else {
+		log.Printf("no proxy configured, going direct")
+		base = parser.NewTransport(config.HTTP)
+	}
+
+	// The egress is verified around the cache, an answer of the echo
+	// endpoint from disk says nothing about the current exit.
+	config.Egress = egress.New(policy, parser.NewClient(config.HTTP, base))
+
+	if cacheConfig != nil {
+		cache := httpcache.New(*cacheConfig, base)
+		config.Cache = cache
+		base = cache
 	}
+	config.Client = parser.NewClient(config.HTTP, base)
 
-	httpTransport := &http.Transport{}
-	http.DefaultClient.Transport = httpTransport
-	httpTransport.DialContext = contextDialer.DialContext
+	return pool
 }
 
-func main() {
-	var ticker *time.Ticker
-	var jobber func(f *parser.FarmaParser)
+// cacheConfig is the response cache of the crawl, nil without --cache. It
+// is kept in CACHE_DIR, "cache" by default, and revalidated after
+// CACHE_TTL, e.g. "12h".
+func cacheConfig(enabled bool, only bool) *httpcache.Config {
+	if !enabled && !only {
+		return nil
+	}
 
-	err := godotenv.Load()
+	config := &httpcache.Config{Dir: os.Getenv("CACHE_DIR"), Only: only}
+	if config.Dir == "" {
+		config.Dir = "cache"
+	}
+
+	if raw := os.Getenv("CACHE_TTL"); raw != "" {
+		ttl, err := time.ParseDuration(raw)
+		if err != nil {
+			log.Fatalf("bad CACHE_TTL value `%s`: %v", raw, err)
+		}
+		config.TTL = ttl
+	}
+
+	return config
+}
+
+func workers() int {
+	raw := os.Getenv("WORKERS")
+	if raw == "" {
+		return 4
+	}
+
+	n, err := strconv.Atoi(raw)
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		log.Fatalf("bad WORKERS value `%s`: %v", raw, err)
 	}
-	setUpProxy()
 
-	args := os.Args[1:]
+	return n
+}
 
-	switch args[0] {
-	case "oz":
-		ticker = time.NewTicker(2 * time.Second)
-		jobber = oz.Jobber
-	case "gz":
-		ticker = time.NewTicker(time.Second)
-		jobber = gz.Jobber
-	case "hp":
-		ticker = time.NewTicker(time.Second)
-		jobber = hp.Jobber
+func dictDir() string {
+	if dir := os.Getenv("DICT_DIR"); dir != "" {
+		return dir
+	}
+
+	return "dicts"
+}
+
+func loadDict(kind string) *dict.Dictionary {
+	d, err := dict.Load(kind, dictDir())
+	if err != nil {
+		log.Fatalf("%s dictionary not loaded: %v", kind, err)
+	}
+
+	return d
+}
+
+func checkpointStore() checkpoint.Store {
+	switch os.Getenv("CHECKPOINT_STORE") {
+	case "mongo":
+		store := mongodb.NewCheckpointStore(mongodb.NewMongoClient())
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := store.EnsureIndexes(ctx); err != nil {
+			log.Printf("checkpoint indexes not created: %v", err)
+		}
+		return store
+	case "", "file":
+		dir := os.Getenv("CHECKPOINT_DIR")
+		if dir == "" {
+			dir = "checkpoints"
+		}
+		return checkpoint.NewFileStore(dir)
 	default:
-		log.Fatalf("Unknown jobber `%s`!", args[0])
+		log.Fatalf("unknown CHECKPOINT_STORE `%s`", os.Getenv("CHECKPOINT_STORE"))
 	}
 
-	fmt.Printf("started `%s`\n", args[0])
+	return nil
+}
+
+// setUpSinks sets the medicaments, dead letters and rejects sinks of the
+// config, and the price history recorder, which is only kept in mongo.
+func setUpSinks(config *parser.Config, kind string, name string, out string) {
+	switch kind {
+	case "mongo":
+		mClient := mongodb.NewMongoClient()
+		mClient.CollectionName = name
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := mClient.EnsureIndexes(ctx); err != nil {
+			log.Fatal(err)
+		}
+		if err := mClient.EnsureSnapshotIndexes(ctx); err != nil {
+			log.Fatal(err)
+		}
+
+		prices := mongodb.NewPriceHistory(mClient)
+		if err := prices.EnsureIndexes(ctx); err != nil {
+			log.Fatal(err)
+		}
 
-	parser.NewRawFarmaParser(ticker, args[1]).Run(jobber)
+		config.Sink = mongodb.NewBulkWriter(mClient, mongodb.BulkConfig{})
+		config.DeadLetters = mClient.WithCollection(name + "_dead")
+		config.Rejects = mClient.WithCollection(name + "_rejects")
+		config.Prices = prices
+	case "jsonl":
+		if out == "" {
+			out = name + ".jsonl"
+		}
+		base := strings.TrimSuffix(out, ".jsonl")
 
-	fmt.Println("parsed")
+		var err error
+		for path, s := range map[string]*sink.Sink{
+			out:                     &config.Sink,
+			base + ".dead.jsonl":    &config.DeadLetters,
+			base + ".rejects.jsonl": &config.Rejects,
+		} {
+			*s, err = sink.NewJSONLFile(path)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+	case "stdout":
+		config.Sink = sink.NewStdout()
+		config.DeadLetters = sink.NewStderr()
+		config.Rejects = sink.NewStderr()
+	default:
+		log.Fatalf("unknown sink `%s`", kind)
+	}
+}
+
+// interrupts cancels the returned context on the first SIGINT or SIGTERM
+// and sends the exit code of that signal, 130 or 143. The signals are let go
+// right then, a second one kills the process even if the drain is stuck.
+func interrupts() (context.Context, <-chan int) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	codes := make(chan int, 1)
+	go func() {
+		sig := <-signals
+		signal.Stop(signals)
+
+		if sig == syscall.SIGTERM {
+			codes <- 143
+		} else {
+			codes <- 130
+		}
+		cancel()
+	}()
+
+	return ctx, codes
+}
+
+func main() {
+	// The environment may come from elsewhere, e.g. docker compose, a .env
+	// file is optional. One that is there has to load.
+	err := godotenv.Load()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Fatalf("Error loading .env file: %v", err)
+	}
+
+	args := os.Args[1:]
+	if len(args) == 0 {
+		log.Fatal("usage: farma <source> <collection> [flags] | farma sources | farma diff <source> <runA> <runB> | farma match --mnn <mnn>")
+	}
+
+	if args[0] == "diff" {
+		diffCommand(args[1:])
+		return
+	}
+	if args[0] == "match" {
+		matchCommand(args[1:])
+		return
+	}
+	if args[0] == "dict" {
+		dictCommand(args[1:])
+		return
+	}
+	if args[0] == "sources" {
+		fmt.Println(strings.Join(parser.Sources(), "\n"))
+		return
+	}
+
+	source, ok := parser.Lookup(args[0])
+	if !ok {
+		log.Fatalf("unknown source `%s`, known are: %s", args[0], strings.Join(parser.Sources(), ", "))
+	}
+	if len(args) < 2 {
+		log.Fatalf("usage: farma %s <collection> [flags]", args[0])
+	}
+
+	config := source.Config()
+
+	flags := flag.NewFlagSet(args[0], flag.ExitOnError)
+	resume := flags.Bool("resume", false, "continue the previous crawl from its checkpoint")
+	sinkKind := flags.String("sink", "mongo", "where to write medicaments: mongo, jsonl or stdout")
+	out := flags.String("out", "", "file of the jsonl sink, <collection>.jsonl by default")
+	cache := flags.Bool("cache", false, "cache responses on disk and reuse them")
+	cacheOnly := flags.Bool("cache-only", false, "parse from the cache only, without any request")
+	flags.Parse(args[2:])
+
+	config.Workers = workers()
+	config.Checkpoint, err = checkpoint.Open(checkpointStore(), args[0]+"-"+args[1], *resume)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	config.Source = source.Name()
+	config.RunID = parser.NewRunID()
+	config.MNNs = loadDict(dict.MNN)
+	config.Manufacturers = loadDict(dict.MANUFACTURER)
+	setUpSinks(&config, *sinkKind, args[1], *out)
+
+	fmt.Fprintf(os.Stderr, "started `%s`\n", args[0])
+
+	ctx, codes := interrupts()
+
+	pool := setUpClient(ctx, &config, cacheConfig(*cache, *cacheOnly))
+
+	summary, err := parser.NewRawFarmaParser(config).Run(ctx, source)
+
+	fmt.Fprintf(os.Stderr, "parsed: %s\n", summary)
+	if pool != nil {
+		for _, stats := range pool.Stats() {
+			fmt.Fprintf(
+				os.Stderr, "proxy %s: %d requests, %d failures, %d bans, healthy %t, quarantined %t\n",
+				stats.URL, stats.Requests, stats.Failures, stats.Bans, stats.Healthy, stats.Quarantined,
+			)
+		}
+	}
+
+	closeCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, s := range []sink.Sink{config.Sink, config.DeadLetters, config.Rejects} {
+		if err := s.Close(closeCtx); err != nil {
+			log.Printf("sink not closed: %v", err)
+		}
+	}
+
+	if ctx.Err() != nil {
+		fmt.Fprintln(os.Stderr, "interrupted")
+		cancel()
+		os.Exit(<-codes)
+	} else if err != nil {
+		log.Fatal(err)
+	}
 
-	fmt.Println("ended")
+	fmt.Fprintln(os.Stderr, "ended")
 }
@@ -0,0 +1,212 @@
+package match
+
+import (
+	"farma/canonical"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_THRESHOLD float64 = 0.6
+
+	METHOD_EXACT string = "exact"
+	METHOD_FUZZY string = "fuzzy"
+
+	// FUZZY_DISCOUNT keeps fuzzy members below every exact one of their
+	// group.
+	FUZZY_DISCOUNT float64 = 0.9
+)
+
+// Member is one product of a match group.
+type Member struct {
+	Source     string  `json:"source" bson:"source"`
+	Key        string  `json:"key" bson:"key"`
+	Title      string  `json:"title" bson:"title"`
+	Price      float64 `json:"price" bson:"price"`
+	Method     string  `json:"method" bson:"method"`
+	Confidence float64 `json:"confidence" bson:"confidence"`
+}
+
+// Match is the same drug across pharmacies.
+type Match struct {
+	ID           string    `json:"id" bson:"_id"`
+	MNN          string    `json:"mnn" bson:"mnn"`
+	MNNID        string    `json:"mnn_id,omitempty" bson:"mnn_id,omitempty"`
+	Manufacturer string    `json:"manufacturer" bson:"manufacturer"`
+	Dosage       string    `json:"dosage" bson:"dosage"`
+	PackCount    int       `json:"pack_count" bson:"pack_count"`
+	Form         string    `json:"form" bson:"form"`
+	Members      []*Member `json:"members" bson:"members"`
+	UpdatedAt    time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// ExactKey is the identity of a drug built from its normalized general
+// info. Products without MNN or dosage have none.
+func ExactKey(m *canonical.Medicament) (string, bool) {
+	if m.MNN == "" || m.Dosage == "" {
+		return "", false
+	}
+
+	return strings.Join([]string{
+		id(m.MNNID, m.MNN),
+		id(m.ManufacturerID, m.Manufacturer),
+		compact(m.Dosage),
+		strconv.Itoa(m.PackCount),
+		Normalize(m.Form),
+	}, "|"), true
+}
+
+// id prefers the dictionary id over the name it was mapped from.
+func id(dictID string, name string) string {
+	if dictID != "" {
+		return dictID
+	}
+
+	return Normalize(name)
+}
+
+// exactConfidence is lower when the key misses manufacturer or pack size,
+// since those make different products look the same.
+func exactConfidence(m *canonical.Medicament) float64 {
+	confidence := 1.0
+	if m.Manufacturer == "" {
+		confidence -= 0.2
+	}
+	if m.PackCount == 0 {
+		confidence -= 0.1
+	}
+	if m.Form == "" {
+		confidence -= 0.1
+	}
+
+	return confidence
+}
+
+func member(m *canonical.Medicament, method string, confidence float64) *Member {
+	return &Member{
+		Source:     m.Source,
+		Key:        m.Key,
+		Title:      m.Title,
+		Price:      m.Price,
+		Method:     method,
+		Confidence: confidence,
+	}
+}
+
+// conflicts tells whether a product states a dosage, pack count or form
+// other than the group's. Zero values say nothing and never conflict.
+func conflicts(g *Match, m *canonical.Medicament) bool {
+	if m.Dosage != "" && g.Dosage != "" && compact(m.Dosage) != g.Dosage {
+		return true
+	}
+	if m.PackCount != 0 && g.PackCount != 0 && m.PackCount != g.PackCount {
+		return true
+	}
+	if m.Form != "" && g.Form != "" && Normalize(m.Form) != g.Form {
+		return true
+	}
+
+	return false
+}
+
+// block is the first title word, fuzzy matching only compares titles
+// sharing it.
+func block(title string) string {
+	words := strings.Fields(Normalize(title))
+	if len(words) == 0 {
+		return ""
+	}
+
+	return words[0]
+}
+
+// Group matches products by their exact key first. Products without one
+// join the group whose titles they resemble the most, if the similarity
+// reaches threshold and nothing they state contradicts the group. Their
+// confidence stays below the one of the group's exact members. Only groups with products of more than one source are
+// returned.
+func Group(meds []*canonical.Medicament, threshold float64) []*Match {
+	now := time.Now()
+	groups := map[string]*Match{}
+	titles := map[string][]string{}
+	blocks := map[string][]string{}
+	floor := map[string]float64{}
+	rest := []*canonical.Medicament{}
+
+	for _, m := range meds {
+		key, ok := ExactKey(m)
+		if !ok {
+			rest = append(rest, m)
+			continue
+		}
+
+		g, ok := groups[key]
+		if !ok {
+			g = &Match{
+				ID:           key,
+				MNN:          Normalize(m.MNN),
+				MNNID:        m.MNNID,
+				Manufacturer: Normalize(m.Manufacturer),
+				Dosage:       compact(m.Dosage),
+				PackCount:    m.PackCount,
+				Form:         Normalize(m.Form),
+				Members:      []*Member{},
+				UpdatedAt:    now,
+			}
+			groups[key] = g
+			b := block(m.Title)
+			blocks[b] = append(blocks[b], key)
+		}
+
+		confidence := exactConfidence(m)
+		if f, ok := floor[key]; !ok || confidence < f {
+			floor[key] = confidence
+		}
+		g.Members = append(g.Members, member(m, METHOD_EXACT, confidence))
+		titles[key] = append(titles[key], m.Title)
+	}
+
+	for _, m := range rest {
+		var best string
+		var bestScore float64
+
+		for _, key := range blocks[block(m.Title)] {
+			if conflicts(groups[key], m) {
+				continue
+			}
+			for _, title := range titles[key] {
+				if score := Similarity(m.Title, title); score > bestScore {
+					best, bestScore = key, score
+				}
+			}
+		}
+
+		if best != "" && bestScore >= threshold {
+			confidence := bestScore
+			if confidence > floor[best] {
+				confidence = floor[best]
+			}
+			groups[best].Members = append(groups[best].Members, member(m, METHOD_FUZZY, confidence*FUZZY_DISCOUNT))
+		}
+	}
+
+	result := []*Match{}
+	for _, g := range groups {
+		sources := map[string]bool{}
+		for _, m := range g.Members {
+			sources[m.Source] = true
+		}
+
+		if len(sources) > 1 {
+			result = append(result, g)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+
+	return result
+}
@@ -0,0 +1,52 @@
+package match
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Normalize folds case and ё, and turns punctuation runs into single
+// spaces, so "Нурофен® таб. п/о" and "нурофен таб п о" compare equal.
+func Normalize(s string) string {
+	s = strings.ToLower(s)
+	s = strings.ReplaceAll(s, "ё", "е")
+
+	return strings.Join(strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '.' && r != ','
+	}), " ")
+}
+
+// compact drops every space, for values like "200 мг" vs "200мг".
+func compact(s string) string {
+	return strings.ReplaceAll(Normalize(s), " ", "")
+}
+
+func trigrams(s string) map[string]bool {
+	result := map[string]bool{}
+
+	for _, word := range strings.Fields(Normalize(s)) {
+		runes := []rune(" " + word + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			result[string(runes[i:i+3])] = true
+		}
+	}
+
+	return result
+}
+
+// Similarity is the Jaccard index of the word trigrams of a and b.
+func Similarity(a string, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+
+	common := 0
+	for t := range ta {
+		if tb[t] {
+			common++
+		}
+	}
+
+	return float64(common) / float64(len(ta)+len(tb)-common)
+}
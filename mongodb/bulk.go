@@ -0,0 +1,196 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"farma/sink"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	DEFAULT_BULK_SIZE    int           = 500
+	DEFAULT_BULK_WINDOW  time.Duration = 5 * time.Second
+	DEFAULT_BULK_RETRIES int           = 3
+	BULK_RETRY_DELAY     time.Duration = 2 * time.Second
+)
+
+type BulkConfig struct {
+	Size    int
+	Window  time.Duration
+	Retries int
+}
+
+// BulkWriter is a sink.Sink that buffers records and writes them with one
+// BulkWrite per batch. A batch is written once it has Size records or its
+// first record is Window old, whichever comes first.
+type BulkWriter struct {
+	mc        *MongoClient
+	config    BulkConfig
+	mu        sync.Mutex
+	pending   []mongo.WriteModel
+	keys      []string
+	acks      []func()
+	snapshots []interface{}
+	timer     *time.Timer
+	stats     sink.Stats
+}
+
+func NewBulkWriter(mc *MongoClient, config BulkConfig) *BulkWriter {
+	if config.Size < 1 {
+		config.Size = DEFAULT_BULK_SIZE
+	}
+	if config.Window <= 0 {
+		config.Window = DEFAULT_BULK_WINDOW
+	}
+	if config.Retries <= 0 {
+		config.Retries = DEFAULT_BULK_RETRIES
+	}
+
+	return &BulkWriter{mc: mc, config: config}
+}
+
+func (bw *BulkWriter) Write(ctx context.Context, item interface{}) error {
+	model, snap, key, err := writeModels(item, time.Now())
+	if err != nil {
+		return err
+	}
+
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+
+	bw.pending = append(bw.pending, model)
+	bw.keys = append(bw.keys, key)
+	bw.acks = append(bw.acks, sink.AckOf(item))
+	if snap != nil {
+		bw.snapshots = append(bw.snapshots, snap)
+	}
+
+	if len(bw.pending) >= bw.config.Size {
+		return bw.flush(ctx)
+	}
+
+	if bw.timer == nil {
+		bw.timer = time.AfterFunc(bw.config.Window, bw.flushWindow)
+	}
+
+	return nil
+}
+
+func (bw *BulkWriter) flushWindow() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if err := bw.Flush(ctx); err != nil {
+		log.Printf("bulk write failed: %v", err)
+	}
+}
+
+func (bw *BulkWriter) Flush(ctx context.Context) error {
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+
+	return bw.flush(ctx)
+}
+
+// flush writes the pending batch. Documents rejected by the server are
+// reported one by one and retried on their own; if the whole batch fails
+// it is retried as a whole. Whatever is left after Retries is dropped and
+// counted as failed. Written records are acked, dropped ones never are.
+func (bw *BulkWriter) flush(ctx context.Context) error {
+	if bw.timer != nil {
+		bw.timer.Stop()
+		bw.timer = nil
+	}
+
+	models, keys, acks := bw.pending, bw.keys, bw.acks
+	bw.pending, bw.keys, bw.acks = nil, nil, nil
+	if len(models) == 0 {
+		return nil
+	}
+
+	if len(bw.snapshots) > 0 {
+		snapshots := bw.mc.client.Database(DB_NAME).Collection(SNAPSHOTS_COLLECTION)
+		if _, err := snapshots.InsertMany(ctx, bw.snapshots, options.InsertMany().SetOrdered(false)); err != nil {
+			log.Printf("bulk write: snapshots not written: %v", err)
+		}
+		bw.snapshots = nil
+	}
+
+	collection := bw.mc.client.Database(DB_NAME).Collection(bw.mc.CollectionName)
+	started := time.Now()
+	defer func() { bw.stats.Duration += time.Since(started) }()
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(BULK_RETRY_DELAY * time.Duration(attempt)):
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+
+		bw.stats.Batches++
+		_, err = collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		if err == nil {
+			bw.stats.Written += int64(len(models))
+			sink.Acks(acks)
+			return nil
+		}
+
+		var bulkErr mongo.BulkWriteException
+		if errors.As(err, &bulkErr) && len(bulkErr.WriteErrors) > 0 {
+			failed := map[int]bool{}
+			failedModels := []mongo.WriteModel{}
+			failedKeys := []string{}
+			failedAcks := []func(){}
+			for _, writeErr := range bulkErr.WriteErrors {
+				log.Printf("bulk write: document %q failed: %s", keys[writeErr.Index], writeErr.Message)
+				failed[writeErr.Index] = true
+				failedModels = append(failedModels, models[writeErr.Index])
+				failedKeys = append(failedKeys, keys[writeErr.Index])
+				failedAcks = append(failedAcks, acks[writeErr.Index])
+			}
+			for i, ack := range acks {
+				if !failed[i] && ack != nil {
+					ack()
+				}
+			}
+
+			bw.stats.Written += int64(len(models) - len(failedModels))
+			models, keys, acks = failedModels, failedKeys, failedAcks
+		}
+
+		if attempt >= bw.config.Retries {
+			break
+		}
+	}
+
+	bw.stats.Failed += int64(len(models))
+
+	return fmt.Errorf("bulk write: %d documents not written: %w", len(models), err)
+}
+
+func (bw *BulkWriter) Stats() sink.Stats {
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+
+	return bw.stats
+}
+
+func (bw *BulkWriter) Close(ctx context.Context) error {
+	if err := bw.Flush(ctx); err != nil {
+		return err
+	}
+
+	return bw.mc.Close(ctx)
+}
@@ -0,0 +1,115 @@
+package mongodb
+
+import (
+	"context"
+	"farma/checkpoint"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	CHECKPOINTS_COLLECTION string = "checkpoints"
+	VISITED_COLLECTION     string = "checkpoint_visited"
+)
+
+// visited is one page visited by a checkpointed crawl.
+type visited struct {
+	Checkpoint string `bson:"checkpoint"`
+	Href       string `bson:"href"`
+}
+
+// CheckpointStore keeps crawl checkpoints in the checkpoints collection
+// and their visited pages in the checkpoint_visited one, a document each.
+type CheckpointStore struct {
+	mc *MongoClient
+}
+
+func NewCheckpointStore(mc *MongoClient) *CheckpointStore {
+	return &CheckpointStore{mc: mc}
+}
+
+func (s *CheckpointStore) collection() *mongo.Collection {
+	return s.mc.client.Database(DB_NAME).Collection(CHECKPOINTS_COLLECTION)
+}
+
+func (s *CheckpointStore) visited() *mongo.Collection {
+	return s.mc.client.Database(DB_NAME).Collection(VISITED_COLLECTION)
+}
+
+func (s *CheckpointStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.visited().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "checkpoint", Value: 1}},
+	})
+
+	return err
+}
+
+func (s *CheckpointStore) Load(id string) (*checkpoint.State, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var state *checkpoint.State
+	err := s.collection().FindOne(ctx, bson.M{"_id": id}).Decode(&state)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	cursor, err := s.visited().Find(ctx, bson.M{"checkpoint": id})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	state.Visited = []string{}
+	for cursor.Next(ctx) {
+		var v visited
+		if err := cursor.Decode(&v); err != nil {
+			return nil, err
+		}
+		state.Visited = append(state.Visited, v.Href)
+	}
+
+	return state, cursor.Err()
+}
+
+func (s *CheckpointStore) Save(state *checkpoint.State) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.collection().ReplaceOne(
+		ctx,
+		bson.M{"_id": state.ID},
+		state,
+		options.Replace().SetUpsert(true),
+	)
+
+	return err
+}
+
+func (s *CheckpointStore) Visit(id string, hrefs []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	docs := make([]interface{}, 0, len(hrefs))
+	for _, href := range hrefs {
+		docs = append(docs, &visited{Checkpoint: id, Href: href})
+	}
+
+	_, err := s.visited().InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+
+	return err
+}
+
+func (s *CheckpointStore) Clear(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.visited().DeleteMany(ctx, bson.M{"checkpoint": id})
+
+	return err
+}
@@ -2,6 +2,7 @@ package mongodb
 
 import (
 	"context"
+	"farma/sink"
 	"log"
 	"time"
 
@@ -17,6 +18,7 @@ const (
 
 type MongoClient struct {
 	client         *mongo.Client
+	shared         bool
 	CollectionName string
 }
 
@@ -40,34 +42,51 @@ func NewMongoClient() *MongoClient {
 	return &MongoClient{client: client()}
 }
 
-func (mc *MongoClient) InsertMany(collectionName string, items []interface{}) int {
+// WithCollection returns a client sharing the connection that writes into
+// another collection. Closing it leaves the connection open.
+func (mc *MongoClient) WithCollection(collectionName string) *MongoClient {
+	return &MongoClient{client: mc.client, shared: true, CollectionName: collectionName}
+}
+
+func (mc *MongoClient) InsertOne(ctx context.Context, collectionName string, item interface{}) error {
 	collection := mc.client.Database(DB_NAME).Collection(collectionName)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	_, err := collection.InsertOne(ctx, item)
 
-	result, err := collection.InsertMany(ctx, items)
+	return err
+}
+
+// Write implements sink.Sink.
+func (mc *MongoClient) Write(ctx context.Context, item interface{}) error {
+	model, snap, _, err := writeModels(item, time.Now())
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	cnt := len(result.InsertedIDs)
+	collection := mc.client.Database(DB_NAME).Collection(mc.CollectionName)
+	if _, err := collection.BulkWrite(ctx, []mongo.WriteModel{model}); err != nil {
+		return err
+	}
 
-	return cnt
-}
+	if ack := sink.AckOf(item); ack != nil {
+		ack()
+	}
 
-func (mc *MongoClient) InsertOne(collectionName string, item interface{}) {
-	collection := mc.client.Database(DB_NAME).Collection(collectionName)
+	if snap != nil {
+		return mc.InsertOne(ctx, SNAPSHOTS_COLLECTION, snap)
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	return nil
+}
 
-	_, err := collection.InsertOne(ctx, item)
-	if err != nil {
-		log.Fatal(err)
-	}
+func (mc *MongoClient) Flush(ctx context.Context) error {
+	return nil
 }
 
-func (mc *MongoClient) Insert(item interface{}) {
-	mc.InsertOne(mc.CollectionName, item)
+func (mc *MongoClient) Close(ctx context.Context) error {
+	if mc.shared {
+		return nil
+	}
+
+	return mc.client.Disconnect(ctx)
 }
@@ -0,0 +1,91 @@
+package mongodb
+
+import (
+	"context"
+	"farma/canonical"
+	"farma/match"
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const MATCHES_COLLECTION string = "matches"
+
+func (mc *MongoClient) matches() *mongo.Collection {
+	return mc.client.Database(DB_NAME).Collection(MATCHES_COLLECTION)
+}
+
+// Canonicals loads the canonical form of every product in the collection.
+func (mc *MongoClient) Canonicals(ctx context.Context, collectionName string) ([]*canonical.Medicament, error) {
+	collection := mc.client.Database(DB_NAME).Collection(collectionName)
+
+	cursor, err := collection.Find(
+		ctx,
+		bson.M{"canonical": bson.M{"$exists": true}},
+		options.Find().SetProjection(bson.M{"canonical": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	result := []*canonical.Medicament{}
+	for cursor.Next(ctx) {
+		var doc struct {
+			Canonical *canonical.Medicament `bson:"canonical"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		result = append(result, doc.Canonical)
+	}
+
+	return result, cursor.Err()
+}
+
+// ReplaceMatches swaps the matches collection for a freshly built one.
+func (mc *MongoClient) ReplaceMatches(ctx context.Context, matches []*match.Match) error {
+	if _, err := mc.matches().DeleteMany(ctx, bson.M{}); err != nil {
+		return err
+	}
+
+	if len(matches) == 0 {
+		return nil
+	}
+
+	docs := make([]interface{}, len(matches))
+	for i, m := range matches {
+		docs[i] = m
+	}
+	if _, err := mc.matches().InsertMany(ctx, docs); err != nil {
+		return err
+	}
+
+	_, err := mc.matches().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "mnn", Value: 1}},
+	})
+
+	return err
+}
+
+// FindMatches returns the groups whose MNN starts with mnn, or whose MNN
+// dictionary id is mnn.
+func (mc *MongoClient) FindMatches(ctx context.Context, mnn string) ([]*match.Match, error) {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"mnn": bson.M{"$regex": "^" + regexp.QuoteMeta(match.Normalize(mnn))}},
+		bson.M{"mnn_id": strings.ToLower(strings.TrimSpace(mnn))},
+	}}
+
+	cursor, err := mc.matches().Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*match.Match{}
+	err = cursor.All(ctx, &result)
+
+	return result, err
+}
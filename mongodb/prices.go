@@ -0,0 +1,165 @@
+package mongodb
+
+import (
+	"context"
+	"farma/history"
+	"math"
+	"sort"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const PRICES_COLLECTION string = "prices"
+
+// PriceHistory keeps the price series of every product in the prices
+// collection, one document per change.
+type PriceHistory struct {
+	mc   *MongoClient
+	mu   sync.Mutex
+	last map[string]*history.Observation
+}
+
+func NewPriceHistory(mc *MongoClient) *PriceHistory {
+	return &PriceHistory{mc: mc, last: map[string]*history.Observation{}}
+}
+
+func (ph *PriceHistory) collection() *mongo.Collection {
+	return ph.mc.client.Database(DB_NAME).Collection(PRICES_COLLECTION)
+}
+
+func (ph *PriceHistory) EnsureIndexes(ctx context.Context) error {
+	_, err := ph.collection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "source", Value: 1}, {Key: "key", Value: 1}, {Key: "observed_at", Value: -1}}},
+		{Keys: bson.D{{Key: "source", Value: 1}, {Key: "run_id", Value: 1}}},
+	})
+
+	return err
+}
+
+func (ph *PriceHistory) latest(ctx context.Context, source string, key string) (*history.Observation, error) {
+	var o *history.Observation
+
+	err := ph.collection().FindOne(
+		ctx,
+		bson.M{"source": source, "key": key},
+		options.FindOne().SetSort(bson.D{{Key: "observed_at", Value: -1}}),
+	).Decode(&o)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+
+	return o, err
+}
+
+// Record implements history.Recorder. The latest observation of every
+// product is cached, so the collection is read once per product.
+func (ph *PriceHistory) Record(ctx context.Context, o *history.Observation) error {
+	id := o.Source + "\x00" + o.Key
+
+	ph.mu.Lock()
+	prev, cached := ph.last[id]
+	ph.mu.Unlock()
+
+	if !cached {
+		var err error
+		prev, err = ph.latest(ctx, o.Source, o.Key)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !o.Changed(prev) {
+		return nil
+	}
+
+	if _, err := ph.collection().InsertOne(ctx, o); err != nil {
+		return err
+	}
+
+	ph.mu.Lock()
+	ph.last[id] = o
+	ph.mu.Unlock()
+
+	return nil
+}
+
+// Series returns every recorded price change of a product, oldest first.
+func (ph *PriceHistory) Series(ctx context.Context, source string, key string) ([]*history.Observation, error) {
+	cursor, err := ph.collection().Find(
+		ctx,
+		bson.M{"source": source, "key": key},
+		options.Find().SetSort(bson.D{{Key: "observed_at", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	series := []*history.Observation{}
+	err = cursor.All(ctx, &series)
+
+	return series, err
+}
+
+// Movers compares the prices a source had after run a and after the later
+// run b and returns up to limit products with the biggest relative change.
+// Only changes are stored, so the price after a run is the one recorded by
+// it or by the latest run before it. Run ids sort by their start.
+func (ph *PriceHistory) Movers(ctx context.Context, source string, a string, b string, limit int) ([]*history.Move, error) {
+	cursor, err := ph.collection().Find(
+		ctx,
+		bson.M{"source": source, "run_id": bson.M{"$lte": b}},
+		options.Find().SetSort(bson.D{{Key: "run_id", Value: 1}, {Key: "observed_at", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	before := map[string]float64{}
+	after := map[string]float64{}
+	for cursor.Next(ctx) {
+		var o history.Observation
+		if err := cursor.Decode(&o); err != nil {
+			return nil, err
+		}
+
+		// Zero prices were stored for pages the price was not read from.
+		if o.Price <= 0 {
+			continue
+		}
+		if o.RunID <= a {
+			before[o.Key] = o.Price
+		}
+		after[o.Key] = o.Price
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	moves := []*history.Move{}
+	for key, from := range before {
+		to := after[key]
+		if to == from {
+			continue
+		}
+
+		move := &history.Move{Key: key, From: from, To: to, Change: to - from}
+		if from != 0 {
+			move.ChangePct = move.Change / from * 100
+		}
+		moves = append(moves, move)
+	}
+
+	sort.Slice(moves, func(i, j int) bool {
+		return math.Abs(moves[i].ChangePct) > math.Abs(moves[j].ChangePct)
+	})
+
+	if limit > 0 && len(moves) > limit {
+		moves = moves[:limit]
+	}
+
+	return moves, nil
+}
@@ -0,0 +1,73 @@
+package mongodb
+
+import (
+	"context"
+	"encoding/json"
+	"farma/diff"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const SNAPSHOTS_COLLECTION string = "snapshots"
+
+// snapshot is a keyed record as it was seen by one crawl run.
+type snapshot struct {
+	Source string `bson:"source"`
+	RunID  string `bson:"run_id"`
+	Key    string `bson:"key"`
+	Hash   string `bson:"content_hash"`
+	Data   bson.M `bson:"data"`
+}
+
+func (mc *MongoClient) snapshots() *mongo.Collection {
+	return mc.client.Database(DB_NAME).Collection(SNAPSHOTS_COLLECTION)
+}
+
+func (mc *MongoClient) EnsureSnapshotIndexes(ctx context.Context) error {
+	_, err := mc.snapshots().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "source", Value: 1}, {Key: "run_id", Value: 1}, {Key: "key", Value: 1}},
+	})
+
+	return err
+}
+
+// Snapshot loads every product the source had in the run.
+func (mc *MongoClient) Snapshot(ctx context.Context, source string, runID string) (diff.Snapshot, error) {
+	cursor, err := mc.snapshots().Find(ctx, bson.M{"source": source, "run_id": runID})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	result := diff.Snapshot{}
+	for cursor.Next(ctx) {
+		var snap snapshot
+		if err := cursor.Decode(&snap); err != nil {
+			return nil, err
+		}
+
+		data, err := plain(snap.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		result[snap.Key] = &diff.Item{Hash: snap.Hash, Data: data}
+	}
+
+	return result, cursor.Err()
+}
+
+// plain turns bson values into the ones encoding/json produces, so that
+// equal fields compare equal no matter how mongo typed them.
+func plain(doc bson.M) (map[string]interface{}, error) {
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var result map[string]interface{}
+	err = json.Unmarshal(b, &result)
+
+	return result, err
+}
@@ -0,0 +1,145 @@
+package mongodb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"farma/sink"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	KEY_FIELD           string = "key"
+	HASH_FIELD          string = "content_hash"
+	FIRST_SEEN_AT_FIELD string = "first_seen_at"
+	LAST_SEEN_AT_FIELD  string = "last_seen_at"
+)
+
+// contentHash is stable across crawls because json sorts map keys.
+func contentHash(item interface{}) (string, error) {
+	b, err := json.Marshal(item)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func toDoc(item interface{}) (bson.M, error) {
+	b, err := bson.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc bson.M
+	if err := bson.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	delete(doc, "_id")
+
+	return doc, nil
+}
+
+// upsertModel builds the update of the document with the given natural key.
+// Tags are stored next to the item fields but are not part of its hash.
+func upsertModel(key string, item interface{}, tags bson.M, seenAt time.Time) (*mongo.UpdateOneModel, string, error) {
+	doc, err := toDoc(item)
+	if err != nil {
+		return nil, "", err
+	}
+
+	hash, err := contentHash(item)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for field, value := range tags {
+		doc[field] = value
+	}
+	doc[KEY_FIELD] = key
+	doc[HASH_FIELD] = hash
+	doc[LAST_SEEN_AT_FIELD] = seenAt
+
+	return mongo.NewUpdateOneModel().
+		SetFilter(bson.M{KEY_FIELD: key}).
+		SetUpdate(bson.M{
+			"$set":         doc,
+			"$setOnInsert": bson.M{FIRST_SEEN_AT_FIELD: seenAt},
+		}).
+		SetUpsert(true), hash, nil
+}
+
+// writeModels turns a sink item into the write of the crawled document and,
+// for keyed records of a run, the snapshot of it in that run. Keyed items
+// are upserted by their key, the rest is inserted as is.
+func writeModels(item interface{}, seenAt time.Time) (mongo.WriteModel, *snapshot, string, error) {
+	data := item
+	tags := bson.M{}
+	var key string
+	var record *sink.Record
+
+	if r, ok := item.(*sink.Record); ok {
+		record = r
+		data = r.Data
+		key = r.Key
+		tags["source"] = r.Source
+		tags["run_id"] = r.RunID
+		if r.Canonical != nil {
+			tags["canonical"] = r.Canonical
+		}
+	} else if keyed, ok := item.(sink.Keyed); ok {
+		key = keyed.Key()
+	}
+
+	if key == "" {
+		doc, err := toDoc(data)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		for field, value := range tags {
+			doc[field] = value
+		}
+
+		return mongo.NewInsertOneModel().SetDocument(doc), nil, key, nil
+	}
+
+	model, hash, err := upsertModel(key, data, tags, seenAt)
+	if err != nil || record == nil || record.RunID == "" {
+		return model, nil, key, err
+	}
+
+	doc, err := toDoc(data)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	return model, &snapshot{
+		Source: record.Source,
+		RunID:  record.RunID,
+		Key:    key,
+		Hash:   hash,
+		Data:   doc,
+	}, key, nil
+}
+
+// EnsureIndexes creates the unique natural key index. Documents stored
+// before upserts existed have no key and are left out of it.
+func (mc *MongoClient) EnsureIndexes(ctx context.Context) error {
+	collection := mc.client.Database(DB_NAME).Collection(mc.CollectionName)
+
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: KEY_FIELD, Value: 1}},
+		Options: options.Index().
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{KEY_FIELD: bson.M{"$exists": true}}),
+	})
+
+	return err
+}
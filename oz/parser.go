@@ -2,70 +2,254 @@ package oz
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"farma/history"
 	"farma/jq"
 	"farma/parser"
+	"farma/ratelimit"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"os"
+	"strconv"
+	"time"
 )
 
-var URL string
+const (
+	MAX_FAILED_PAGES int = 5
+	PAGE_SIZE        int = 20
+)
+
+type pageInfo struct {
+	CurrentPage int `json:"current_page"`
+	PageSize    int `json:"page_size"`
+	TotalPages  int `json:"total_pages"`
+}
+
+type pageResponse struct {
+	Data struct {
+		ProductDetail struct {
+			TotalCount int               `json:"total_count"`
+			PageInfo   pageInfo          `json:"page_info"`
+			Items      []json.RawMessage `json:"items"`
+		} `json:"productDetail"`
+	} `json:"data"`
+}
+
+type product map[string]interface{}
+
+// Key is the oz product id, or sku for products without one.
+func (p product) Key() string {
+	if id, ok := p["id"]; ok && id != nil {
+		return keyString(id)
+	}
+
+	return keyString(p["sku"])
+}
+
+func (p product) Observation() *history.Observation {
+	price, ok := p["price"].(float64)
+	if !ok || price <= 0 {
+		return nil
+	}
+	currency, _ := p["currency"].(string)
+
+	o := &history.Observation{
+		Key:      p.Key(),
+		Price:    price,
+		Currency: currency,
+	}
+	if inStock, ok := p["is_in_stock"].(bool); ok {
+		o.InStock = &inStock
+	}
+
+	return o
+}
+
+// flatten collects the jq outputs, which are either single products or
+// arrays of them.
+func flatten(outs []interface{}) []product {
+	result := []product{}
+
+	for _, out := range outs {
+		switch v := out.(type) {
+		case map[string]interface{}:
+			result = append(result, product(v))
+		case []interface{}:
+			result = append(result, flatten(v)...)
+		}
+	}
+
+	return result
+}
+
+// keyString is "" for a missing value, products without key are inserted
+// rather than upserted.
+func keyString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	return fmt.Sprint(v)
+}
 
 type requestJson struct {
 	Query     string         `json:"query"`
 	Variables map[string]int `json:"variables"`
 }
 
-func Jobber(f *parser.FarmaParser) {
-	var err error
-	var tmpBytes []byte
-	var graphqlQuery string
-	var jqQuery string
+// Source crawls the oz GraphQL catalog. Its frontier is the page numbers,
+// the total is learned from the first page, which is kept for Parse.
+type Source struct {
+	query     string
+	program   *jq.Program
+	failed    int
+	first     []byte
+	firstPage *pageResponse
+}
+
+func init() {
+	parser.Register(&Source{})
+}
+
+func (s *Source) Name() string {
+	return "oz"
+}
+
+func (s *Source) Config() parser.Config {
+	return parser.Config{
+		Limits: ratelimit.Config{RPS: 0.5, Burst: 1},
+		Retry: parser.RetryPolicy{
+			MaxAttempts: 8,
+			BaseDelay:   5 * time.Second,
+			MaxDelay:    5 * time.Minute,
+			Jitter:      0.3,
+		},
+		HTTP: parser.ClientConfig{
+			Timeout:         3 * time.Minute,
+			MaxConnsPerHost: 2,
+			Middlewares: []parser.Middleware{
+				parser.UserAgents(),
+				parser.Headers(map[string]string{"Accept": "application/json"}),
+				parser.Headers(parser.DEFAULT_HEADERS),
+			},
+		},
+		Transform: "files/oz.canonical.jq",
+	}
+}
 
-	URL = os.Getenv("OZ_URL")
+func (s *Source) load() {
+	if s.program != nil {
+		return
+	}
 
-	tmpBytes, err = ioutil.ReadFile("files/oz.graphql")
+	tmpBytes, err := ioutil.ReadFile("files/oz.graphql")
 	if err != nil {
 		log.Fatal(err)
 	}
-	graphqlQuery = string(tmpBytes)
+	s.query = string(tmpBytes)
 
-	tmpBytes, err = ioutil.ReadFile("files/oz.jq")
+	s.program, err = jq.CompileFile("files/oz.jq")
 	if err != nil {
 		log.Fatal(err)
 	}
-	jqQuery = string(tmpBytes)
+}
 
-	for i := 0; ; i++ {
-		f.Jobs <- &parser.ResponseJob{
-			Type:    "bytes",
-			Request: request(graphqlQuery, i, 20),
-		}
+func (s *Source) page(ctx context.Context, f *parser.FarmaParser, i int) ([]byte, *pageResponse, error) {
+	rspBytes, err := f.Bytes(request(ctx, f.URL(""), s.query, i, PAGE_SIZE))
+	if err != nil {
+		return nil, nil, err
+	}
 
-		rspBytes := <-f.RspBytes
-		if rspBytes.Err != nil {
-			log.Fatal(err)
-		}
+	var page pageResponse
+	if err := json.Unmarshal(rspBytes, &page); err != nil {
+		return nil, nil, &parser.DecodeError{URL: f.URL(""), Err: err}
+	}
 
-		transformed, err := jq.Transform(
-			map[string]interface{}{
-				"response_body": string(rspBytes.Bytes),
-			},
-			string(jqQuery),
-		)
-		if err != nil {
-			log.Fatal(err)
+	return rspBytes, &page, nil
+}
+
+// Discover asks the first page how many there are.
+func (s *Source) Discover(ctx context.Context, f *parser.FarmaParser) ([]string, error) {
+	s.load()
+
+	rspBytes, page, err := s.page(ctx, f, 1)
+	if err != nil {
+		return nil, fmt.Errorf("first page: %w", err)
+	}
+	s.first, s.firstPage = rspBytes, page
+
+	info := page.Data.ProductDetail.PageInfo
+	log.Printf("oz: %d products on %d pages", page.Data.ProductDetail.TotalCount, info.TotalPages)
+
+	pages := []string{}
+	for i := 1; i <= info.TotalPages; i++ {
+		pages = append(pages, strconv.Itoa(i))
+	}
+
+	return pages, nil
+}
+
+// Parse emits the products of a page. The crawl stops if the query is
+// rejected or MAX_FAILED_PAGES pages in a row fail.
+func (s *Source) Parse(ctx context.Context, f *parser.FarmaParser, href string) error {
+	s.load()
+
+	i, err := strconv.Atoi(href)
+	if err != nil {
+		return err
+	}
+
+	var rspBytes []byte
+	var page *pageResponse
+	if i == 1 && s.first != nil {
+		rspBytes, page = s.first, s.firstPage
+		s.first, s.firstPage = nil, nil
+	} else {
+		rspBytes, page, err = s.page(ctx, f, i)
+	}
+
+	var statusErr *parser.StatusError
+	switch {
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case errors.As(err, &statusErr) && !statusErr.Retryable():
+		return fmt.Errorf("query rejected on page %d: %v: %w", i, err, parser.ErrStop)
+	case err != nil:
+		s.failed++
+		if s.failed >= MAX_FAILED_PAGES {
+			return fmt.Errorf("%d pages in a row failed at page %d: %w", s.failed, i, parser.ErrStop)
 		}
+		return err
+	}
+	s.failed = 0
+
+	if len(page.Data.ProductDetail.Items) == 0 {
+		return fmt.Errorf("page %d is empty: %w", i, parser.ErrStop)
+	}
 
-		for _, rawMed := range transformed.([]interface{}) {
-			f.RawMedicaments <- rawMed
+	outs, err := s.program.RunAll(map[string]interface{}{
+		"response_body": string(rspBytes),
+	})
+	if err != nil {
+		return &parser.DecodeError{URL: f.URL(""), Err: err}
+	}
+
+	for _, rawMed := range flatten(outs) {
+		if err := f.Emit(ctx, rawMed); err != nil {
+			return err
 		}
 	}
+
+	return nil
 }
 
-func request(query string, pageNumber int, pSize int) *http.Request {
+func request(ctx context.Context, url string, query string, pageNumber int, pSize int) *http.Request {
 	reqBodyObject := &requestJson{
 		Query: query,
 		Variables: map[string]int{
@@ -78,11 +262,10 @@ func request(query string, pageNumber int, pSize int) *http.Request {
 		log.Fatal(err)
 	}
 
-	req, err := http.NewRequest("POST", URL, bytes.NewBuffer(reqBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(reqBody))
 	if err != nil {
 		log.Fatal(err)
 	}
-	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:88.0) Gecko/20100101 Firefox/88.0")
 	req.Header.Set("Content-Type", "application/json")
 
 	return req
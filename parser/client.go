@@ -0,0 +1,113 @@
+package parser
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Cache is a response cache in the client transport. Requests it answers
+// without the network skip the rate limiter.
+type Cache interface {
+	Fresh(r *http.Request) bool
+}
+
+// Middleware wraps the transport of a client, e.g. to set headers.
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// ClientConfig is the HTTP side of a source. Zero fields take the
+// DefaultClientConfig values, Cookies gives the client a cookie jar of its
+// own. Middlewares run in order, the first one sees the request first, nil
+// Middlewares are DefaultMiddlewares.
+type ClientConfig struct {
+	Timeout               time.Duration
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	MaxConnsPerHost       int
+	MaxIdleConnsPerHost   int
+	InsecureSkipVerify    bool
+	Cookies               bool
+	Middlewares           []Middleware
+}
+
+var DefaultClientConfig = ClientConfig{
+	Timeout:               2 * time.Minute,
+	DialTimeout:           30 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ResponseHeaderTimeout: time.Minute,
+	MaxIdleConnsPerHost:   8,
+}
+
+func (c ClientConfig) withDefaults() ClientConfig {
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultClientConfig.Timeout
+	}
+	if c.DialTimeout <= 0 {
+		c.DialTimeout = DefaultClientConfig.DialTimeout
+	}
+	if c.TLSHandshakeTimeout <= 0 {
+		c.TLSHandshakeTimeout = DefaultClientConfig.TLSHandshakeTimeout
+	}
+	if c.ResponseHeaderTimeout <= 0 {
+		c.ResponseHeaderTimeout = DefaultClientConfig.ResponseHeaderTimeout
+	}
+	if c.MaxIdleConnsPerHost <= 0 {
+		c.MaxIdleConnsPerHost = DefaultClientConfig.MaxIdleConnsPerHost
+	}
+	if c.Middlewares == nil {
+		c.Middlewares = DefaultMiddlewares()
+	}
+
+	return c
+}
+
+// NewTransport builds a direct transport of the config. Proxy pools clone
+// it for every proxy.
+func NewTransport(config ClientConfig) *http.Transport {
+	config = config.withDefaults()
+
+	dialer := &net.Dialer{
+		Timeout:   config.DialTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	return &http.Transport{
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   config.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: config.ResponseHeaderTimeout,
+		MaxConnsPerHost:       config.MaxConnsPerHost,
+		MaxIdleConnsPerHost:   config.MaxIdleConnsPerHost,
+		IdleConnTimeout:       90 * time.Second,
+		TLSClientConfig:       &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify},
+	}
+}
+
+// NewClient builds the client of a source on top of base, a direct
+// transport of the config if base is nil.
+func NewClient(config ClientConfig, base http.RoundTripper) *http.Client {
+	config = config.withDefaults()
+
+	if base == nil {
+		base = NewTransport(config)
+	}
+	for i := len(config.Middlewares) - 1; i >= 0; i-- {
+		base = config.Middlewares[i](base)
+	}
+
+	client := &http.Client{
+		Transport: base,
+		Timeout:   config.Timeout,
+	}
+
+	if config.Cookies {
+		// cookiejar.New only fails with options it does not get here.
+		client.Jar, _ = cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	}
+
+	return client
+}
@@ -0,0 +1,70 @@
+package parser
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const SNIPPET_SIZE int = 512
+
+// StatusError is returned for any response outside of 2xx.
+type StatusError struct {
+	Code    int
+	URL     string
+	Snippet string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("bad response status code %d for %s: %q", e.Code, e.URL, e.Snippet)
+}
+
+// Retryable treats throttling and server side failures as transient.
+func (e *StatusError) Retryable() bool {
+	return e.Code == http.StatusTooManyRequests ||
+		e.Code == http.StatusRequestTimeout ||
+		e.Code >= 500
+}
+
+// TransportError is a failure to send the request or read the response.
+type TransportError struct {
+	URL string
+	Err error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("transport error for %s: %v", e.URL, e.Err)
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeError is a response body that could not be parsed.
+type DecodeError struct {
+	URL string
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decode error for %s: %v", e.URL, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+func (e *DecodeError) Retryable() bool {
+	return false
+}
+
+// snippet is the start of a response body, SNIPPET_SIZE characters at most.
+// It is cut by runes, the pages are mostly Cyrillic.
+func snippet(b []byte) string {
+	s := strings.TrimSpace(string(b))
+	if runes := []rune(s); len(runes) > SNIPPET_SIZE {
+		s = string(runes[:SNIPPET_SIZE])
+	}
+
+	return s
+}
@@ -0,0 +1,32 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSnippet(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"short", "  not found\n", "not found"},
+		{"ascii", strings.Repeat("a", SNIPPET_SIZE+10), strings.Repeat("a", SNIPPET_SIZE)},
+		{"cyrillic", strings.Repeat("я", SNIPPET_SIZE+10), strings.Repeat("я", SNIPPET_SIZE)},
+		{"mixed", "a" + strings.Repeat("ж", SNIPPET_SIZE), "a" + strings.Repeat("ж", SNIPPET_SIZE-1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := snippet([]byte(tt.body))
+			if got != tt.want {
+				t.Errorf("snippet is %d runes, want %d", utf8.RuneCountInString(got), utf8.RuneCountInString(tt.want))
+			}
+			if !utf8.ValidString(got) {
+				t.Error("snippet is not valid UTF-8")
+			}
+		})
+	}
+}
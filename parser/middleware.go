@@ -0,0 +1,144 @@
+package parser
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// USER_AGENTS are the browsers requests pretend to come from by default.
+var USER_AGENTS = []string{
+	"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:88.0) Gecko/20100101 Firefox/88.0",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:88.0) Gecko/20100101 Firefox/88.0",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/90.0.4430.93 Safari/537.36",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/14.1 Safari/605.1.15",
+	"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/90.0.4430.93 Safari/537.36",
+}
+
+var DEFAULT_HEADERS = map[string]string{
+	"Accept":          "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+	"Accept-Language": "ru-RU,ru;q=0.9,en-US;q=0.5,en;q=0.3",
+}
+
+// DefaultMiddlewares are used by clients configured without any.
+func DefaultMiddlewares() []Middleware {
+	return []Middleware{
+		UserAgents(),
+		Headers(DEFAULT_HEADERS),
+		Referer(),
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// setHeaders returns a copy of r with the headers it does not have yet,
+// round trippers must not change the request they are given.
+func setHeaders(r *http.Request, headers map[string]string) *http.Request {
+	var clone *http.Request
+	for name, value := range headers {
+		if value == "" || r.Header.Get(name) != "" {
+			continue
+		}
+		if clone == nil {
+			clone = r.Clone(r.Context())
+		}
+		clone.Header.Set(name, value)
+	}
+
+	if clone == nil {
+		return r
+	}
+	return clone
+}
+
+// UserAgents sets the User-Agent of requests without one to one of agents,
+// USER_AGENTS if there are none. The agent is picked once per client it is
+// built into, a browser does not change between requests of a cookie
+// session. Clients take turns over agents from a run dependent start.
+func UserAgents(agents ...string) Middleware {
+	if len(agents) == 0 {
+		agents = USER_AGENTS
+	}
+	next := uint32(time.Now().UnixNano())
+
+	return func(rt http.RoundTripper) http.RoundTripper {
+		agent := agents[int(atomic.AddUint32(&next, 1)%uint32(len(agents)))]
+
+		return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			return rt.RoundTrip(setHeaders(r, map[string]string{"User-Agent": agent}))
+		})
+	}
+}
+
+// Headers sets the headers requests do not have yet.
+func Headers(headers map[string]string) Middleware {
+	return func(rt http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			return rt.RoundTrip(setHeaders(r, headers))
+		})
+	}
+}
+
+type refererKey struct{}
+
+// WithReferer makes requests of ctx look like they follow a link on the
+// page at url.
+func WithReferer(ctx context.Context, url string) context.Context {
+	return context.WithValue(ctx, refererKey{}, url)
+}
+
+// Referer sets the Referer of requests to the page WithReferer put into
+// their context.
+func Referer() Middleware {
+	return func(rt http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			referer, _ := r.Context().Value(refererKey{}).(string)
+			return rt.RoundTrip(setHeaders(r, map[string]string{"Referer": referer}))
+		})
+	}
+}
+
+// Exchange is a finished request as logging hooks see it. Response is nil
+// if Err is not.
+type Exchange struct {
+	Request  *http.Request
+	Response *http.Response
+	Err      error
+	Took     time.Duration
+}
+
+// Observe calls before, if not nil, with every request and after with
+// every finished exchange.
+func Observe(before func(*http.Request), after func(*Exchange)) Middleware {
+	return func(rt http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			if before != nil {
+				before(r)
+			}
+
+			started := time.Now()
+			resp, err := rt.RoundTrip(r)
+			if after != nil {
+				after(&Exchange{Request: r, Response: resp, Err: err, Took: time.Since(started)})
+			}
+
+			return resp, err
+		})
+	}
+}
+
+// LogExchange is an after hook of Observe writing one line per request.
+func LogExchange(e *Exchange) {
+	if e.Err != nil {
+		log.Printf("%s %s failed after %s: %v", e.Request.Method, e.Request.URL, e.Took.Round(time.Millisecond), e.Err)
+		return
+	}
+
+	log.Printf("%s %s %d in %s", e.Request.Method, e.Request.URL, e.Response.StatusCode, e.Took.Round(time.Millisecond))
+}
@@ -1,47 +1,35 @@
 package parser
 
 import (
-	"encoding/json"
+	"bytes"
+	"context"
+	"errors"
+	"farma/checkpoint"
+	"farma/dict"
+	"farma/egress"
+	"farma/history"
 	"farma/jq"
-	"farma/mongodb"
+	"farma/ratelimit"
+	"farma/sink"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
 )
 
-const URL_CHECK_IP string = "https://api.myip.com/"
-
-type image struct {
-	Thumbnail string
-	Main      string
-}
-
-type generalInfo struct {
-	MNN          string
-	Manufacturer string
-	Doze         string
-	Numero       string
-	Form         string
-}
-
-type medicament struct {
-	Title       string
-	URL         string
-	Price       float32
-	Images      []*image
-	Groups      []string
-	GeneralInfo *generalInfo
-	Description map[string]string
-	Attributes  map[string]string
-}
+const FLUSH_TIMEOUT time.Duration = time.Minute
 
 type ResponseJob struct {
-	Type    string
-	Request *http.Request
+	Type     string
+	Request  *http.Request
+	RspDocs  chan *RspDoc
+	RspBytes chan *RspByte
 }
 
 type RspDoc struct {
@@ -54,44 +42,141 @@ type RspByte struct {
 	Err   error
 }
 
+type Config struct {
+	Source        string
+	BaseURL       string
+	RunID         string
+	Limits        ratelimit.Config
+	HTTP          ClientConfig
+	Client        *http.Client
+	Cache         Cache
+	Egress        *egress.Verifier
+	Retry         RetryPolicy
+	Workers       int
+	Checkpoint    *checkpoint.Checkpoint
+	Sink          sink.Sink
+	DeadLetters   sink.Sink
+	Prices        history.Recorder
+	Rejects       sink.Sink
+	Transform     string
+	MNNs          *dict.Dictionary
+	Manufacturers *dict.Dictionary
+}
+
 type FarmaParser struct {
-	ticker         *time.Ticker
-	RspDocs        chan *RspDoc
-	RspBytes       chan *RspByte
+	source         string
+	baseURL        string
+	runID          string
+	client         *http.Client
+	cache          Cache
+	egress         *egress.Verifier
+	limiter        *ratelimit.Limiter
+	retry          RetryPolicy
+	workers        int
+	requests       int64
+	pages          int64
+	items          int64
+	errors         int64
 	Jobs           chan *ResponseJob
-	RawMedicaments chan interface{}
-	instructionsJQ string
-	mongoClient    *mongodb.MongoClient
-	needTransform  bool
+	Checkpoint     *checkpoint.Checkpoint
+	RawMedicaments chan *sink.Record
+	transform      *jq.Program
+	sink           sink.Sink
+	deadLetters    sink.Sink
+	rejects        sink.Sink
+	prices         history.Recorder
+	mnns           *dict.Dictionary
+	manufacturers  *dict.Dictionary
 }
 
-func NewRawFarmaParser(ticker *time.Ticker, collectionName string) *FarmaParser {
-	mClient := mongodb.NewMongoClient()
-	mClient.CollectionName = collectionName
+func NewRawFarmaParser(config Config) *FarmaParser {
+	if config.Workers < 1 {
+		config.Workers = 1
+	}
+	if config.Retry.MaxAttempts < 1 {
+		config.Retry = DefaultRetryPolicy
+	}
+	if config.Checkpoint == nil {
+		config.Checkpoint, _ = checkpoint.Open(nil, "crawl", false)
+	}
+	if config.Client == nil {
+		config.Client = NewClient(config.HTTP, nil)
+	}
+	if config.Egress == nil {
+		config.Egress = egress.New(egress.DefaultPolicy, config.Client)
+	}
+	if config.BaseURL == "" {
+		config.BaseURL = BaseURL(config.Source)
+	}
+	if config.RunID == "" {
+		config.RunID = NewRunID()
+	}
+	if config.Sink == nil {
+		config.Sink = sink.NewStdout()
+	}
+	if config.DeadLetters == nil {
+		config.DeadLetters = sink.NewStderr()
+	}
+	if config.Rejects == nil {
+		config.Rejects = sink.NewStderr()
+	}
+
+	var transform *jq.Program
+	if config.Transform != "" {
+		var err error
+		transform, err = jq.CompileFile(config.Transform, jq.WithVariables("$source"))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	return &FarmaParser{
-		ticker:         ticker,
-		RspDocs:        make(chan *RspDoc),
-		RspBytes:       make(chan *RspByte),
+		source:         config.Source,
+		baseURL:        config.BaseURL,
+		runID:          config.RunID,
+		client:         config.Client,
+		cache:          config.Cache,
+		egress:         config.Egress,
+		limiter:        ratelimit.NewLimiter(config.Limits),
+		retry:          config.Retry,
+		workers:        config.Workers,
 		Jobs:           make(chan *ResponseJob),
-		RawMedicaments: make(chan interface{}),
-		mongoClient:    mClient,
-		needTransform:  false,
+		Checkpoint:     config.Checkpoint,
+		RawMedicaments: make(chan *sink.Record),
+		sink:           config.Sink,
+		deadLetters:    config.DeadLetters,
+		prices:         config.Prices,
+		rejects:        config.Rejects,
+		transform:      transform,
+		mnns:           config.MNNs,
+		manufacturers:  config.Manufacturers,
 	}
 }
 
-type checkProxyResult struct {
-	IP      string `json:"ip"`
-	Country string `json:"country"`
-	CC      string `json:"cc"`
-}
-
 func (f *FarmaParser) response(r *http.Request) (*http.Response, error) {
-	resp, err := http.DefaultClient.Do(r)
+	if f.cache == nil || !f.cache.Fresh(r) {
+		if err := f.limiter.Wait(r.Context(), r.URL.Host); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := f.client.Do(r)
 	if err != nil {
-		return nil, err
-	} else if resp.StatusCode < 200 && resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("bad response status code: %d", resp.StatusCode)
+		return nil, &TransportError{URL: r.URL.String(), Err: err}
+	}
+	f.limiter.Observe(r.URL.Host, resp)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+
+		head, _ := io.ReadAll(io.LimitReader(resp.Body, int64(SNIPPET_SIZE)))
+		io.Copy(io.Discard, resp.Body)
+
+		return nil, &StatusError{
+			Code:    resp.StatusCode,
+			URL:     r.URL.String(),
+			Snippet: snippet(head),
+		}
 	}
 
 	return resp, nil
@@ -106,100 +191,279 @@ func (f *FarmaParser) responseBytes(r *http.Request) ([]byte, error) {
 
 	bytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, &TransportError{URL: r.URL.String(), Err: err}
 	}
 
 	return bytes, nil
 }
 
 func (f *FarmaParser) responseDoc(r *http.Request) (*goquery.Document, error) {
-	resp, err := f.response(r)
+	body, err := f.responseBytes(r)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
 	if err != nil {
-		return nil, err
+		return nil, &DecodeError{URL: r.URL.String(), Err: err}
 	}
 
 	return doc, nil
 }
 
-func (f *FarmaParser) runParse() {
-	var c int
-
-	for {
-		job := <-f.Jobs
+func (f *FarmaParser) runParse(wg *sync.WaitGroup) {
+	defer wg.Done()
 
+	for job := range f.Jobs {
 		switch job.Type {
 		case "doc":
-			doc, err := f.responseDoc(job.Request)
-			f.RspDocs <- &RspDoc{doc, err}
+			var doc *goquery.Document
+			err := f.withRetries(job.Request, func(r *http.Request) (err error) {
+				doc, err = f.responseDoc(r)
+				return err
+			})
+			f.count(err)
+			job.RspDocs <- &RspDoc{doc, err}
 		case "bytes":
-			bytes, err := f.responseBytes(job.Request)
-			f.RspBytes <- &RspByte{bytes, err}
+			var bytes []byte
+			err := f.withRetries(job.Request, func(r *http.Request) (err error) {
+				bytes, err = f.responseBytes(r)
+				return err
+			})
+			f.count(err)
+			job.RspBytes <- &RspByte{bytes, err}
 		default:
 			log.Fatal(fmt.Sprintf("unknown job type `%s`", job.Type))
 		}
 
-		fmt.Println(c, time.Now().Format("2006-01-02T15:04:05.000Z"))
-		c++
+		c := atomic.AddInt64(&f.requests, 1)
+		fmt.Fprintln(os.Stderr, c-1, time.Now().Format("2006-01-02T15:04:05.000Z"))
+	}
+}
 
-		<-f.ticker.C
+func (f *FarmaParser) count(err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	} else if err != nil {
+		atomic.AddInt64(&f.errors, 1)
+	} else {
+		atomic.AddInt64(&f.pages, 1)
 	}
 }
 
-func (f *FarmaParser) checkProxy() *checkProxyResult {
-	resp, err := http.DefaultClient.Get(URL_CHECK_IP)
-	if err != nil {
-		log.Fatal(err)
+// submit hands job to the fetch workers unless the request context is done.
+func (f *FarmaParser) submit(job *ResponseJob) error {
+	ctx := job.Request.Context()
+
+	select {
+	case f.Jobs <- job:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	defer resp.Body.Close()
+}
 
-	var cpr *checkProxyResult
-	err = json.NewDecoder(resp.Body).Decode(&cpr)
-	if err != nil {
-		log.Fatal(err)
-	} else if cpr.CC == "RU" {
-		log.Fatal(fmt.Sprintf("broken proxy: check IP result - %q", cpr))
+// Doc queues the request to the fetch workers and waits for the parsed page.
+func (f *FarmaParser) Doc(r *http.Request) (*goquery.Document, error) {
+	rspDocs := make(chan *RspDoc, 1)
+	if err := f.submit(&ResponseJob{Type: "doc", Request: r, RspDocs: rspDocs}); err != nil {
+		return nil, err
 	}
 
-	return cpr
+	rspDoc := <-rspDocs
+	return rspDoc.Doc, rspDoc.Err
 }
 
-func (f *FarmaParser) transformJSON(data interface{}) (*medicament, error) {
-	transformed, err := jq.Transform(data, f.instructionsJQ)
-	if err != nil {
+// Bytes queues the request to the fetch workers and waits for the raw body.
+func (f *FarmaParser) Bytes(r *http.Request) ([]byte, error) {
+	rspBytes := make(chan *RspByte, 1)
+	if err := f.submit(&ResponseJob{Type: "bytes", Request: r, RspBytes: rspBytes}); err != nil {
 		return nil, err
 	}
 
-	return transformed.(*medicament), nil
+	rspByte := <-rspBytes
+	return rspByte.Bytes, rspByte.Err
 }
 
-func (f *FarmaParser) runInsertions() {
-	var data interface{}
-	var err error
+// Emit queues a parsed medicament for insertion.
+func (f *FarmaParser) Emit(ctx context.Context, medicament interface{}) error {
+	return f.EmitAcked(ctx, medicament, nil)
+}
+
+// EmitAcked queues a parsed medicament for insertion and calls ack once the
+// sink stored it. Medicaments rejected by the transform or failed to store
+// are never acked.
+func (f *FarmaParser) EmitAcked(ctx context.Context, medicament interface{}, ack func()) error {
+	record := &sink.Record{Source: f.source, RunID: f.runID, Data: medicament, Ack: ack}
+	if keyed, ok := medicament.(sink.Keyed); ok {
+		record.Key = keyed.Key()
+	}
+
+	select {
+	case f.RawMedicaments <- record:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
-	for {
-		data = <-f.RawMedicaments
+// Fanout calls fn for every href with at most as many calls in flight as
+// there are fetch workers, and returns once all of them are done. No new
+// calls are started once ctx is done.
+func (f *FarmaParser) Fanout(ctx context.Context, hrefs []string, fn func(href string)) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, f.workers)
+
+	for _, href := range hrefs {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		}
+		wg.Add(1)
+
+		go func(href string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			fn(href)
+		}(href)
+	}
+
+	wg.Wait()
+}
 
-		if f.needTransform {
-			data, err = f.transformJSON(data)
-			if err != nil {
-				log.Fatal(err)
-			}
+// runInsertions writes medicaments until RawMedicaments is closed. It is
+// not bound to the crawl context so that everything already parsed still
+// gets stored during shutdown.
+func (f *FarmaParser) runInsertions(done chan<- struct{}) {
+	defer close(done)
+
+	for record := range f.RawMedicaments {
+		ctx, cancel := context.WithTimeout(context.Background(), FLUSH_TIMEOUT)
+		if !f.canonical(ctx, record) {
+			cancel()
+			continue
 		}
-		f.mongoClient.Insert(data)
+
+		err := f.sink.Write(ctx, record)
+		if err == nil {
+			f.recordPrice(ctx, record.Data)
+		}
+		cancel()
+
+		if err != nil {
+			log.Printf("insert failed: %v", err)
+			atomic.AddInt64(&f.errors, 1)
+			continue
+		}
+		atomic.AddInt64(&f.items, 1)
+	}
+}
+
+func (f *FarmaParser) recordPrice(ctx context.Context, data interface{}) {
+	priced, ok := data.(history.Priced)
+	if !ok || f.prices == nil {
+		return
+	}
+
+	o := priced.Observation()
+	if o == nil {
+		return
+	}
+	o.Source = f.source
+	o.RunID = f.runID
+	o.ObservedAt = time.Now()
+
+	if err := f.prices.Record(ctx, o); err != nil {
+		log.Printf("price of `%s` not recorded: %v", o.Key, err)
 	}
 }
 
-func (fp *FarmaParser) Run(f func(*FarmaParser)) {
-	fmt.Printf("Proxy OK: %q\n", *fp.checkProxy())
+// Run crawls source s until it is finished or ctx is done. Either way the
+// fetch workers are stopped, pending medicaments are inserted and the
+// checkpoint is saved before the crawl summary is returned. The error is
+// ctx.Err() for an interrupted crawl and the discovery error for a source
+// that could not find its frontier. The egress is verified first and, if
+// its policy says so, during the crawl, which stops with the egress error
+// once it breaks the policy.
+func (fp *FarmaParser) Run(ctx context.Context, s Source) (*Summary, error) {
+	started := time.Now()
+
+	result, err := fp.egress.Verify(ctx)
+	if err != nil {
+		return &Summary{RunID: fp.runID}, err
+	} else if result != nil {
+		fmt.Fprintf(os.Stderr, "egress OK: %+v\n", *result)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	egressErr := make(chan error, 1)
+	go fp.egress.Watch(ctx, func(err error) {
+		log.Printf("stop crawl: %v", err)
+		egressErr <- err
+		cancel()
+	})
+
+	var workers sync.WaitGroup
+	for i := 0; i < fp.workers; i++ {
+		workers.Add(1)
+		go fp.runParse(&workers)
+	}
 
-	go fp.runParse()
-	go fp.runInsertions()
+	inserted := make(chan struct{})
+	go fp.runInsertions(inserted)
+
+	crawlErr := fp.crawl(ctx, s)
+
+	close(fp.Jobs)
+	workers.Wait()
+
+	close(fp.RawMedicaments)
+	<-inserted
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), FLUSH_TIMEOUT)
+	defer cancel()
+	for _, s := range []sink.Sink{fp.sink, fp.deadLetters, fp.rejects} {
+		if err := s.Flush(flushCtx); err != nil {
+			log.Printf("sink not flushed: %v", err)
+		}
+	}
+
+	if err := fp.Checkpoint.Save(); err != nil {
+		log.Printf("checkpoint not saved: %v", err)
+	}
+	for _, d := range []*dict.Dictionary{fp.mnns, fp.manufacturers} {
+		if d == nil {
+			continue
+		}
+		if err := d.Save(); err != nil {
+			log.Printf("%s dictionary not saved: %v", d.Kind(), err)
+		}
+	}
+
+	summary := &Summary{
+		RunID:    fp.runID,
+		Pages:    atomic.LoadInt64(&fp.pages),
+		Items:    atomic.LoadInt64(&fp.items),
+		Errors:   atomic.LoadInt64(&fp.errors),
+		Duration: time.Since(started),
+	}
+	if statser, ok := fp.sink.(sink.Statser); ok {
+		summary.Writes = statser.Stats()
+	}
+
+	select {
+	case err := <-egressErr:
+		return summary, err
+	default:
+	}
+	if crawlErr != nil {
+		return summary, crawlErr
+	}
 
-	f(fp)
+	return summary, ctx.Err()
 }
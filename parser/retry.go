@@ -0,0 +1,140 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Jitter      float64
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   2 * time.Second,
+	MaxDelay:    2 * time.Minute,
+	Jitter:      0.3,
+}
+
+// delay returns the exponential backoff before the given retry, spread by
+// +-Jitter of its value.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.BaseDelay << uint(attempt-1)
+	if d <= 0 || d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+
+	if p.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(d))
+	}
+
+	return d
+}
+
+type retryable interface {
+	Retryable() bool
+}
+
+// Retryable reports whether a failed fetch is worth another attempt.
+// Errors may decide for themselves by implementing Retryable() bool,
+// otherwise timeouts and dropped connections are retried and everything
+// else (bad markup, malformed requests) is permanent.
+func Retryable(err error) bool {
+	var r retryable
+	if errors.As(err, &r) {
+		return r.Retryable()
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
+
+type deadLetter struct {
+	RunID    string    `json:"run_id"`
+	URL      string    `json:"url"`
+	Method   string    `json:"method"`
+	Error    string    `json:"error"`
+	Attempts int       `json:"attempts"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+func (f *FarmaParser) deadLetter(r *http.Request, err error, attempts int) {
+	log.Printf("dead letter after %d attempt(s) %s %s: %v", attempts, r.Method, r.URL, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), FLUSH_TIMEOUT)
+	defer cancel()
+
+	err = f.deadLetters.Write(ctx, &deadLetter{
+		RunID:    f.runID,
+		URL:      r.URL.String(),
+		Method:   r.Method,
+		Error:    err.Error(),
+		Attempts: attempts,
+		FailedAt: time.Now(),
+	})
+	if err != nil {
+		log.Printf("dead letter lost: %v", err)
+	}
+}
+
+// withRetries calls fetch until it succeeds, fails permanently or runs out
+// of attempts. Final failures are recorded as dead letters, fetches cut
+// short by the request context are not.
+func (f *FarmaParser) withRetries(r *http.Request, fetch func(*http.Request) error) error {
+	var err error
+	ctx := r.Context()
+
+	for attempt := 1; ; attempt++ {
+		req := r
+		if attempt > 1 {
+			req = r.Clone(r.Context())
+			if r.GetBody != nil {
+				req.Body, err = r.GetBody()
+				if err != nil {
+					f.deadLetter(r, err, attempt)
+					return err
+				}
+			}
+		}
+
+		err = fetch(req)
+		if err == nil {
+			return nil
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if !Retryable(err) || attempt >= f.retry.MaxAttempts {
+			f.deadLetter(r, err, attempt)
+			return err
+		}
+
+		delay := f.retry.delay(attempt)
+		log.Printf("attempt %d %s %s failed, retry in %s: %v", attempt, r.Method, r.URL, delay, err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
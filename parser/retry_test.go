@@ -0,0 +1,58 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"syscall"
+	"testing"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func transport(err error) error {
+	return &TransportError{URL: "https://example.com/", Err: &url.Error{Op: "Get", URL: "https://example.com/", Err: err}}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"not found", &StatusError{Code: 404}, false},
+		{"gone", &StatusError{Code: 410}, false},
+		{"bad request", &StatusError{Code: 400}, false},
+		{"forbidden", &StatusError{Code: 403}, false},
+		{"request timeout", &StatusError{Code: 408}, true},
+		{"too many requests", &StatusError{Code: 429}, true},
+		{"internal error", &StatusError{Code: 500}, true},
+		{"bad gateway", &StatusError{Code: 502}, true},
+		{"unavailable", &StatusError{Code: 503}, true},
+		{"wrapped status", fmt.Errorf("page 2: %w", &StatusError{Code: 404}), false},
+		{"timeout", transport(timeoutError{}), true},
+		{"deadline of the request", transport(context.DeadlineExceeded), true},
+		{"connection reset", transport(syscall.ECONNRESET), true},
+		{"connection refused", transport(syscall.ECONNREFUSED), true},
+		{"cut body", transport(io.ErrUnexpectedEOF), true},
+		{"canceled", context.Canceled, false},
+		{"canceled request", transport(context.Canceled), false},
+		{"broken markup", &DecodeError{Err: errors.New("unexpected token")}, false},
+		{"cut markup", &DecodeError{Err: io.ErrUnexpectedEOF}, false},
+		{"malformed request", errors.New("unsupported protocol scheme"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Retryable(tt.err); got != tt.want {
+				t.Errorf("Retryable(%v) = %t, want %t", tt.err, got, tt.want)
+			}
+		})
+	}
+}
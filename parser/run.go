@@ -0,0 +1,17 @@
+package parser
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+const RUN_ID_LAYOUT string = "20060102T150405Z"
+
+// NewRunID returns a sortable id for a crawl run started now.
+func NewRunID() string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+
+	return time.Now().UTC().Format(RUN_ID_LAYOUT) + "-" + hex.EncodeToString(suffix)
+}
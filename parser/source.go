@@ -0,0 +1,179 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ErrStop ends the crawl of a source early when Parse returns it.
+var ErrStop = errors.New("stop crawl")
+
+// Source is a pharmacy the crawler knows. Discover finds the frontier,
+// e.g. catalog or listing pages, and fails if it could not look for it. An
+// empty frontier is an empty catalog. Parse emits the medicaments of one of
+// them.
+// Both fetch through the parser so that limits, retries and checkpoints
+// apply.
+type Source interface {
+	Name() string
+	Config() Config
+	Discover(ctx context.Context, f *FarmaParser) ([]string, error)
+	Parse(ctx context.Context, f *FarmaParser, href string) error
+}
+
+var registry = map[string]Source{}
+
+// Register makes a source available by its name. Sources register in init.
+func Register(s Source) {
+	if _, ok := registry[s.Name()]; ok {
+		log.Fatalf("source `%s` registered twice", s.Name())
+	}
+	registry[s.Name()] = s
+}
+
+// Lookup returns the registered source of that name.
+func Lookup(name string) (Source, bool) {
+	s, ok := registry[name]
+	return s, ok
+}
+
+// Sources lists the names of the registered sources.
+func Sources() []string {
+	names := []string{}
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// BaseURL is where a source lives, read from <NAME>_URL.
+func BaseURL(name string) string {
+	return os.Getenv(strings.ToUpper(name) + "_URL")
+}
+
+// crawl runs through the frontier of the source, discovering it first
+// unless the checkpoint already has it. Entries are done once parsed or
+// failed for good. Those failing with a retryable error, or interrupted,
+// stay in the frontier for a resumed run. A failed discovery fails the
+// crawl.
+func (f *FarmaParser) crawl(ctx context.Context, s Source) error {
+	frontier, discovered := f.Checkpoint.Frontier()
+	if !discovered {
+		var err error
+		frontier, err = s.Discover(ctx, f)
+		if ctx.Err() != nil {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%s: discovery failed: %w", f.source, err)
+		} else if len(frontier) == 0 {
+			log.Printf("%s: nothing discovered", f.source)
+			return nil
+		}
+		f.Checkpoint.SetFrontier(frontier)
+	}
+
+	for _, href := range frontier {
+		err := s.Parse(ctx, f, href)
+		if ctx.Err() != nil {
+			return nil
+		} else if errors.Is(err, ErrStop) {
+			log.Printf("%s: %v", f.source, err)
+			return nil
+		} else if err != nil && Retryable(err) {
+			log.Printf("%s: `%s` left for the next run: %v", f.source, href, err)
+			continue
+		} else if err != nil {
+			f.Skip("`"+href+"`", err)
+		}
+
+		f.Checkpoint.Done(href)
+	}
+
+	return nil
+}
+
+// URL resolves href against the base URL of the source. Absolute hrefs are
+// kept as they are, an empty one is the base URL itself.
+func (f *FarmaParser) URL(href string) string {
+	if href == "" {
+		return f.baseURL
+	} else if strings.HasPrefix(href, "http://") || strings.HasPrefix(href, "https://") {
+		return href
+	}
+
+	return strings.TrimRight(f.baseURL, "/") + "/" + strings.TrimLeft(href, "/")
+}
+
+// Resolve resolves href found on page the way a browser does, so that
+// "?abc=a" or "next/" are relative to page rather than to the base URL. The
+// fragment is dropped. Links into the source come back relative to its
+// base URL, which keeps them usable as keys.
+func (f *FarmaParser) Resolve(page string, href string) string {
+	base, err := url.Parse(f.URL(page))
+	if err != nil {
+		return href
+	}
+	ref, err := url.Parse(href)
+	if err != nil {
+		return href
+	}
+
+	resolved := base.ResolveReference(ref)
+	resolved.Fragment = ""
+
+	root, err := url.Parse(f.baseURL)
+	if err != nil || root.Host == "" || resolved.Scheme != root.Scheme || resolved.Host != root.Host {
+		return resolved.String()
+	}
+	resolved.Scheme, resolved.Host, resolved.User = "", "", nil
+
+	return resolved.String()
+}
+
+// Page fetches and parses a page of the source, with query added to href.
+func (f *FarmaParser) Page(ctx context.Context, href string, query map[string]string) (*goquery.Document, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", f.URL(href), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if query != nil {
+		q := req.URL.Query()
+		for key, val := range query {
+			q.Add(key, val)
+		}
+		req.URL.RawQuery = q.Encode()
+	}
+
+	return f.Doc(req)
+}
+
+// Skip logs why a page is left out of the crawl.
+func (f *FarmaParser) Skip(what string, err error) {
+	var statusErr *StatusError
+	var decodeErr *DecodeError
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return
+	case errors.As(err, &statusErr) && statusErr.Code == http.StatusNotFound:
+		log.Printf("%s: %s is gone", f.source, what)
+	case errors.As(err, &statusErr):
+		log.Printf("%s: skip %s, status %d: %s", f.source, what, statusErr.Code, statusErr.Snippet)
+	case errors.As(err, &decodeErr):
+		log.Printf("%s: skip %s, broken markup: %v", f.source, what, decodeErr.Err)
+	default:
+		log.Printf("%s: skip %s: %v", f.source, what, err)
+	}
+}
@@ -0,0 +1,77 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// fakeSource fails the frontier entries found in errs.
+type fakeSource struct {
+	frontier []string
+	errs     map[string]error
+}
+
+func (s *fakeSource) Name() string   { return "fake" }
+func (s *fakeSource) Config() Config { return Config{} }
+func (s *fakeSource) Discover(ctx context.Context, f *FarmaParser) ([]string, error) {
+	return s.frontier, nil
+}
+
+func (s *fakeSource) Parse(ctx context.Context, f *FarmaParser, href string) error {
+	return s.errs[href]
+}
+
+func TestCrawlKeepsTransientFailures(t *testing.T) {
+	s := &fakeSource{
+		frontier: []string{"ok", "gone", "unavailable", "throttled", "broken"},
+		errs: map[string]error{
+			"gone":        &StatusError{Code: http.StatusNotFound},
+			"unavailable": &StatusError{Code: http.StatusServiceUnavailable},
+			"throttled":   &TransportError{Err: &StatusError{Code: http.StatusTooManyRequests}},
+			"broken":      &DecodeError{Err: errors.New("unexpected EOF")},
+		},
+	}
+
+	f := NewRawFarmaParser(Config{Source: "fake"})
+	if err := f.crawl(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+
+	frontier, discovered := f.Checkpoint.Frontier()
+	if !discovered {
+		t.Fatal("frontier not discovered")
+	}
+	want := []string{"unavailable", "throttled"}
+	if !reflect.DeepEqual(frontier, want) {
+		t.Errorf("frontier = %v, want %v", frontier, want)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	f := NewRawFarmaParser(Config{Source: "fake", BaseURL: "https://pharmacy.test"})
+
+	tests := []struct {
+		page string
+		href string
+		want string
+	}{
+		{"/ingredients/", "?abc=a", "/ingredients/?abc=a"},
+		{"/ingredients/ibuprofen/", "?PAGEN_1=2", "/ingredients/ibuprofen/?PAGEN_1=2"},
+		{"/ingredients/ibuprofen/?PAGEN_1=2", "?PAGEN_1=3", "/ingredients/ibuprofen/?PAGEN_1=3"},
+		{"/catalog/", "nurofen/", "/catalog/nurofen/"},
+		{"/catalog/nurofen/", "#analogs", "/catalog/nurofen/"},
+		{"/catalog/nurofen/", "/product/nurofen-200/", "/product/nurofen-200/"},
+		{"/catalog/nurofen/", "https://pharmacy.test/product/x/", "/product/x/"},
+		{"/catalog/nurofen/", "https://cdn.test/image.jpg", "https://cdn.test/image.jpg"},
+		{"", "letter/a/", "/letter/a/"},
+	}
+
+	for _, tt := range tests {
+		if got := f.Resolve(tt.page, tt.href); got != tt.want {
+			t.Errorf("Resolve(%q, %q) = %q, want %q", tt.page, tt.href, got, tt.want)
+		}
+	}
+}
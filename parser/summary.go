@@ -0,0 +1,35 @@
+package parser
+
+import (
+	"farma/sink"
+	"fmt"
+	"time"
+)
+
+// Summary describes a finished crawl. Items are the records handed to the
+// sink, Written the ones it confirmed.
+type Summary struct {
+	RunID    string
+	Pages    int64
+	Items    int64
+	Errors   int64
+	Duration time.Duration
+	Writes   sink.Stats
+}
+
+// WriteRate is the number of written records per second of crawl.
+func (s *Summary) WriteRate() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+
+	return float64(s.Writes.Written) / s.Duration.Seconds()
+}
+
+func (s *Summary) String() string {
+	return fmt.Sprintf(
+		"run: %s, pages: %d, items: %d, errors: %d, duration: %s, written: %d (%.1f/s, %d batches, %s writing), write failures: %d",
+		s.RunID, s.Pages, s.Items, s.Errors, s.Duration.Round(time.Second),
+		s.Writes.Written, s.WriteRate(), s.Writes.Batches, s.Writes.Duration.Round(time.Millisecond), s.Writes.Failed,
+	)
+}
@@ -0,0 +1,140 @@
+package parser
+
+import (
+	"context"
+	"encoding/json"
+	"farma/canonical"
+	"farma/dosage"
+	"farma/sink"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+type reject struct {
+	RunID      string      `json:"run_id"`
+	Source     string      `json:"source"`
+	Key        string      `json:"key"`
+	Error      string      `json:"error"`
+	Data       interface{} `json:"data"`
+	RejectedAt time.Time   `json:"rejected_at"`
+}
+
+// transformJSON runs the source jq program against the raw record, with
+// $source set to the source name, and decodes its output into the
+// canonical medicament. Both ways go through
+// JSON since gojq only works with plain maps and slices.
+func (f *FarmaParser) transformJSON(data interface{}) (*canonical.Medicament, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var plain interface{}
+	if err := json.Unmarshal(raw, &plain); err != nil {
+		return nil, err
+	}
+
+	transformed, err := f.transform.Run(plain, f.source)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := json.Marshal(transformed)
+	if err != nil {
+		return nil, err
+	}
+
+	var med *canonical.Medicament
+	if err := json.Unmarshal(out, &med); err != nil {
+		return nil, err
+	}
+
+	return med, nil
+}
+
+func (f *FarmaParser) reject(ctx context.Context, record *sink.Record, err error) {
+	log.Printf("transform of `%s` failed: %v", record.Key, err)
+	atomic.AddInt64(&f.errors, 1)
+
+	err = f.rejects.Write(ctx, &reject{
+		RunID:      record.RunID,
+		Source:     record.Source,
+		Key:        record.Key,
+		Error:      err.Error(),
+		Data:       record.Data,
+		RejectedAt: time.Now(),
+	})
+	if err != nil {
+		log.Printf("reject lost: %v", err)
+	}
+}
+
+// canonical maps the record with the source jq program if it has one, or
+// with the record own mapper otherwise. Records the script fails on go to
+// the rejects sink and must not be written.
+func (f *FarmaParser) canonical(ctx context.Context, record *sink.Record) bool {
+	var med *canonical.Medicament
+
+	if f.transform != nil {
+		var err error
+		med, err = f.transformJSON(record.Data)
+		if err != nil {
+			f.reject(ctx, record, err)
+			return false
+		}
+	} else if mapper, ok := record.Data.(canonical.Mapper); ok {
+		med = mapper.Canonical()
+	}
+
+	if med != nil {
+		med.Source = record.Source
+		med.Key = record.Key
+		normalize(med)
+		f.identify(med)
+		record.Canonical = med
+	}
+
+	return true
+}
+
+// normalize parses dosage, form and pack count out of the title, lets the
+// mapped attributes override them and writes them back in one spelling for
+// every source, e.g. "200 мг" and "таблетки".
+func normalize(med *canonical.Medicament) {
+	d := dosage.Parse(med.Title)
+
+	if own := dosage.Parse(med.Dosage); own.Value > 0 {
+		d.Value, d.Unit = own.Value, own.Unit
+	}
+	if form := dosage.Form(med.Form); form != "" {
+		d.Form = form
+	}
+	if med.PackCount > 0 {
+		d.Count = med.PackCount
+	}
+
+	if d.Empty() {
+		return
+	}
+
+	if d.Value > 0 {
+		med.Dosage = d.String()
+	}
+	if d.Form != "" {
+		med.Form = d.Form
+	}
+	med.PackCount = d.Count
+	med.Parsed = d
+}
+
+// identify maps MNN and manufacturer to their dictionary ids. Names without
+// one are left for review with `farma dict unmapped`.
+func (f *FarmaParser) identify(med *canonical.Medicament) {
+	if f.mnns != nil && med.MNN != "" {
+		med.MNNID, _ = f.mnns.Lookup(med.MNN)
+	}
+	if f.manufacturers != nil && med.Manufacturer != "" {
+		med.ManufacturerID, _ = f.manufacturers.Lookup(med.Manufacturer)
+	}
+}
@@ -0,0 +1,51 @@
+package proxypool
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Banned tells responses meaning the site refuses the proxy rather than
+// the request: 403, redirects to a captcha and challenge pages of the
+// common anti-bot services.
+func Banned(resp *http.Response) bool {
+	if resp == nil {
+		return false
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusForbidden:
+		return true
+	case strings.Contains(strings.ToLower(resp.Header.Get("Location")), "captcha"):
+		return true
+	case resp.Header.Get("Cf-Mitigated") == "challenge":
+		return true
+	case resp.Header.Get("X-Yandex-Captcha") != "":
+		return true
+	}
+
+	return false
+}
+
+// BannedError is a response Banned tells, returned by the pool in its
+// place. It is retryable, the next attempt goes through another proxy.
+type BannedError struct {
+	Proxy  string
+	Status int
+}
+
+func (e *BannedError) Error() string {
+	return fmt.Sprintf("proxy %s banned with status %d", e.Proxy, e.Status)
+}
+
+func (e *BannedError) Retryable() bool {
+	return true
+}
+
+// discard drains and closes the body of a response nobody reads.
+func discard(resp *http.Response) {
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	resp.Body.Close()
+}
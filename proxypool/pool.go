@@ -0,0 +1,233 @@
+package proxypool
+
+import (
+	"context"
+	"farma/egress"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ROTATE_REQUEST string = "request"
+	ROTATE_SESSION string = "session"
+)
+
+// ErrNoProxy is retryable, quarantines end and health checks bring proxies
+// back.
+var ErrNoProxy error = noProxyError{}
+
+type noProxyError struct{}
+
+func (noProxyError) Error() string {
+	return "no proxy available"
+}
+
+func (noProxyError) Retryable() bool {
+	return true
+}
+
+// Config of the pool. CheckURL is an echo endpoint answering with the
+// caller IP and country code as JSON. Proxies resolving to a country Allow
+// refuses are unhealthy, a nil Allow takes any. BanStrikes banned responses in a row
+// quarantine a proxy for Quarantine. ROTATE_SESSION keeps a proxy per
+// target host as long as it is available. Transport is the template of the
+// proxy transports.
+type Config struct {
+	CheckURL   string
+	CheckEvery time.Duration
+	Allow      func(cc string) bool
+	BanStrikes int
+	Quarantine time.Duration
+	Rotation   string
+	Transport  *http.Transport
+}
+
+var DefaultConfig = Config{
+	CheckURL:   egress.DEFAULT_ECHO_URL,
+	CheckEvery: 5 * time.Minute,
+	BanStrikes: 3,
+	Quarantine: 30 * time.Minute,
+	Rotation:   ROTATE_REQUEST,
+}
+
+// Pool spreads requests over its proxies. It is an http.RoundTripper.
+type Pool struct {
+	config   Config
+	proxies  []*Proxy
+	next     uint32
+	mu       sync.Mutex
+	sessions map[string]*Proxy
+}
+
+// New makes a pool of the proxy URLs. Zero config fields take their
+// DefaultConfig values.
+func New(urls []string, config Config) (*Pool, error) {
+	if config.CheckURL == "" {
+		config.CheckURL = DefaultConfig.CheckURL
+	}
+	if config.CheckEvery <= 0 {
+		config.CheckEvery = DefaultConfig.CheckEvery
+	}
+	if config.BanStrikes < 1 {
+		config.BanStrikes = DefaultConfig.BanStrikes
+	}
+	if config.Quarantine <= 0 {
+		config.Quarantine = DefaultConfig.Quarantine
+	}
+	if config.Rotation == "" {
+		config.Rotation = DefaultConfig.Rotation
+	}
+	if config.Rotation != ROTATE_REQUEST && config.Rotation != ROTATE_SESSION {
+		return nil, fmt.Errorf("unknown rotation `%s`", config.Rotation)
+	}
+
+	pool := &Pool{config: config, sessions: map[string]*Proxy{}}
+	for _, raw := range urls {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+
+		p, err := Parse(raw, config.Transport)
+		if err != nil {
+			return nil, fmt.Errorf("proxy `%s`: %w", raw, err)
+		}
+		pool.proxies = append(pool.proxies, p)
+	}
+	if len(pool.proxies) == 0 {
+		return nil, ErrNoProxy
+	}
+
+	return pool, nil
+}
+
+// Pick returns the proxy for a request to session, the target host.
+func (pool *Pool) Pick(session string) (*Proxy, error) {
+	now := time.Now()
+
+	if pool.config.Rotation == ROTATE_SESSION {
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+
+		if p, ok := pool.sessions[session]; ok && p.available(now) {
+			return p, nil
+		}
+	}
+
+	n := len(pool.proxies)
+	start := int(atomic.AddUint32(&pool.next, 1))
+	for i := 0; i < n; i++ {
+		p := pool.proxies[(start+i)%n]
+		if p.available(now) {
+			if pool.config.Rotation == ROTATE_SESSION {
+				pool.sessions[session] = p
+			}
+			return p, nil
+		}
+	}
+
+	return nil, ErrNoProxy
+}
+
+func (pool *Pool) RoundTrip(r *http.Request) (*http.Response, error) {
+	p, err := pool.Pick(r.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.transport.RoundTrip(r)
+	p.observe(resp, err, pool.config)
+
+	if err == nil && Banned(resp) {
+		discard(resp)
+		pool.forget(r.URL.Host, p)
+		return nil, &BannedError{Proxy: p.String(), Status: resp.StatusCode}
+	}
+
+	return resp, err
+}
+
+// forget drops the session of a banned proxy, so the next request to the
+// host picks another one.
+func (pool *Pool) forget(session string, p *Proxy) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if pool.sessions[session] == p {
+		delete(pool.sessions, session)
+	}
+}
+
+// Check asks the echo endpoint through every proxy at once, whether they
+// are available or not.
+func (pool *Pool) Check(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for _, p := range pool.proxies {
+		wg.Add(1)
+		go func(p *Proxy) {
+			defer wg.Done()
+
+			started := time.Now()
+			result, err := egress.Echo(ctx, p.transport, pool.config.CheckURL)
+			if ctx.Err() != nil {
+				return
+			} else if err != nil {
+				log.Printf("proxy %s is down: %v", p, err)
+				p.checked(false, "", 0)
+				return
+			}
+
+			healthy := pool.config.Allow == nil || pool.config.Allow(result.CC)
+			if !healthy {
+				log.Printf("proxy %s resolves to %s", p, result.CC)
+			}
+			p.checked(healthy, result.CC, time.Since(started))
+		}(p)
+	}
+
+	wg.Wait()
+}
+
+// Run checks the proxies every CheckEvery until ctx is done.
+func (pool *Pool) Run(ctx context.Context) {
+	ticker := time.NewTicker(pool.config.CheckEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			pool.Check(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Stats of every proxy of the pool.
+func (pool *Pool) Stats() []Stats {
+	result := []Stats{}
+	for _, p := range pool.proxies {
+		result = append(result, p.Stats())
+	}
+
+	return result
+}
+
+// Available is how many proxies can take requests now.
+func (pool *Pool) Available() int {
+	now := time.Now()
+	n := 0
+	for _, p := range pool.proxies {
+		if p.available(now) {
+			n++
+		}
+	}
+
+	return n
+}
@@ -0,0 +1,188 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_RPS         float64       = 1
+	DEFAULT_MIN_BACKOFF time.Duration = 5 * time.Second
+	DEFAULT_MAX_BACKOFF time.Duration = 5 * time.Minute
+)
+
+type Config struct {
+	RPS        float64
+	Burst      int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+type bucket struct {
+	mu           sync.Mutex
+	tokens       float64
+	last         time.Time
+	backoff      time.Duration
+	blockedUntil time.Time
+}
+
+// Limiter is a token bucket per request host. A host that answers with
+// 429/503 is blocked for a growing backoff which shrinks again on success.
+type Limiter struct {
+	config  Config
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// NewLimiter fills zero config fields with defaults. There is no unlimited
+// rate, a host gets DEFAULT_RPS unless told otherwise.
+func NewLimiter(config Config) *Limiter {
+	if config.RPS <= 0 {
+		config.RPS = DEFAULT_RPS
+	}
+	if config.Burst < 1 {
+		config.Burst = 1
+	}
+	if config.MinBackoff == 0 {
+		config.MinBackoff = DEFAULT_MIN_BACKOFF
+	}
+	if config.MaxBackoff == 0 {
+		config.MaxBackoff = DEFAULT_MAX_BACKOFF
+	}
+
+	return &Limiter{
+		config:  config,
+		buckets: map[string]*bucket{},
+	}
+}
+
+func (l *Limiter) bucket(host string) *bucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[host]
+	if !ok {
+		b = &bucket{tokens: float64(l.config.Burst), last: time.Now()}
+		l.buckets[host] = b
+	}
+
+	return b
+}
+
+// Wait blocks until a request to host is allowed or ctx is done.
+func (l *Limiter) Wait(ctx context.Context, host string) error {
+	b := l.bucket(host)
+
+	for {
+		b.mu.Lock()
+		now := time.Now()
+
+		if now.Before(b.blockedUntil) {
+			wait := b.blockedUntil.Sub(now)
+			b.mu.Unlock()
+			if err := sleep(ctx, wait); err != nil {
+				return err
+			}
+			continue
+		}
+
+		b.tokens += now.Sub(b.last).Seconds() * l.config.RPS
+		if b.tokens > float64(l.config.Burst) {
+			b.tokens = float64(l.config.Burst)
+		}
+		b.last = now
+
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return nil
+		}
+
+		wait := time.Duration((1 - b.tokens) / l.config.RPS * float64(time.Second))
+		b.mu.Unlock()
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Backoff blocks host for the doubled backoff, or for retryAfter if the
+// site asked for longer.
+func (l *Limiter) Backoff(host string, retryAfter time.Duration) {
+	b := l.bucket(host)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.backoff *= 2
+	if b.backoff < l.config.MinBackoff {
+		b.backoff = l.config.MinBackoff
+	}
+	if b.backoff > l.config.MaxBackoff {
+		b.backoff = l.config.MaxBackoff
+	}
+
+	wait := b.backoff
+	if retryAfter > wait {
+		wait = retryAfter
+	}
+
+	b.tokens = 0
+	b.blockedUntil = time.Now().Add(wait)
+}
+
+// Success halves the current backoff of host.
+func (l *Limiter) Success(host string) {
+	b := l.bucket(host)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.backoff /= 2
+	if b.backoff < l.config.MinBackoff {
+		b.backoff = 0
+	}
+}
+
+// Observe adapts host limits to the response status.
+func (l *Limiter) Observe(host string, resp *http.Response) {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		l.Backoff(host, RetryAfter(resp.Header))
+	default:
+		l.Success(host)
+	}
+}
+
+// RetryAfter parses the Retry-After header given in seconds or as HTTP date.
+func RetryAfter(h http.Header) time.Duration {
+	raw := h.Get("Retry-After")
+	if raw == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(raw); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if at, err := http.ParseTime(raw); err == nil {
+		return time.Until(at)
+	}
+
+	return 0
+}
@@ -0,0 +1,159 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestWait(t *testing.T) {
+	tests := []struct {
+		name     string
+		rps      float64
+		burst    int
+		requests int
+		min, max time.Duration
+	}{
+		{"within burst", 100, 5, 5, 0, 5 * time.Millisecond},
+		{"past burst", 100, 2, 7, 45 * time.Millisecond, 150 * time.Millisecond},
+		{"no burst", 200, 0, 5, 18 * time.Millisecond, 100 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLimiter(Config{RPS: tt.rps, Burst: tt.burst})
+
+			start := time.Now()
+			for i := 0; i < tt.requests; i++ {
+				if err := l.Wait(context.Background(), "a"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if took := time.Since(start); took < tt.min || took > tt.max {
+				t.Errorf("%d requests took %s, want %s to %s", tt.requests, took, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestWaitPerHost(t *testing.T) {
+	l := NewLimiter(Config{RPS: 1, Burst: 1})
+	l.Wait(context.Background(), "a")
+
+	start := time.Now()
+	if err := l.Wait(context.Background(), "b"); err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(start); took > 5*time.Millisecond {
+		t.Errorf("waited %s for a fresh host", took)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx, "a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("empty bucket: error %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	l := NewLimiter(Config{RPS: 1000, MinBackoff: 10 * time.Second, MaxBackoff: time.Minute})
+
+	// Every step is a response status and the backoff of the host after it.
+	steps := []struct {
+		status  int
+		backoff time.Duration
+	}{
+		{http.StatusTooManyRequests, 10 * time.Second},
+		{http.StatusServiceUnavailable, 20 * time.Second},
+		{http.StatusTooManyRequests, 40 * time.Second},
+		{http.StatusTooManyRequests, time.Minute},
+		{http.StatusTooManyRequests, time.Minute},
+		{http.StatusOK, 30 * time.Second},
+		{http.StatusNotFound, 15 * time.Second},
+		{http.StatusTooManyRequests, 30 * time.Second},
+		{http.StatusOK, 15 * time.Second},
+		{http.StatusOK, 0},
+		{http.StatusOK, 0},
+		{http.StatusTooManyRequests, 10 * time.Second},
+	}
+
+	for i, step := range steps {
+		start := time.Now()
+		l.Observe("a", &http.Response{StatusCode: step.status, Header: http.Header{}})
+
+		b := l.bucket("a")
+		if b.backoff != step.backoff {
+			t.Errorf("step %d, status %d: backoff %s, want %s", i, step.status, b.backoff, step.backoff)
+		}
+		throttled := step.status == http.StatusTooManyRequests || step.status == http.StatusServiceUnavailable
+		if blocked := b.blockedUntil.Sub(start); throttled && (blocked < step.backoff || blocked > step.backoff+time.Second) {
+			t.Errorf("step %d, status %d: blocked for %s, want %s", i, step.status, blocked, step.backoff)
+		}
+	}
+}
+
+func TestBackoffRetryAfter(t *testing.T) {
+	l := NewLimiter(Config{MinBackoff: 10 * time.Second, MaxBackoff: time.Minute})
+
+	tests := []struct {
+		retryAfter string
+		blocked    time.Duration
+	}{
+		{"120", 120 * time.Second},
+		{"1", 20 * time.Second},
+		{"", 40 * time.Second},
+	}
+
+	for _, tt := range tests {
+		start := time.Now()
+		l.Observe("a", &http.Response{
+			StatusCode: http.StatusTooManyRequests,
+			Header:     http.Header{"Retry-After": []string{tt.retryAfter}},
+		})
+
+		if blocked := l.bucket("a").blockedUntil.Sub(start); blocked < tt.blocked || blocked > tt.blocked+time.Second {
+			t.Errorf("Retry-After %q: blocked for %s, want %s", tt.retryAfter, blocked, tt.blocked)
+		}
+	}
+}
+
+func TestBlockedWait(t *testing.T) {
+	l := NewLimiter(Config{RPS: 1000, Burst: 10, MinBackoff: 30 * time.Millisecond})
+	l.Backoff("a", 0)
+
+	start := time.Now()
+	if err := l.Wait(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(start); took < 30*time.Millisecond || took > 200*time.Millisecond {
+		t.Errorf("blocked host waited %s, want 30ms", took)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		min, max time.Duration
+	}{
+		{"missing", "", 0, 0},
+		{"seconds", "120", 120 * time.Second, 120 * time.Second},
+		{"zero", "0", 0, 0},
+		{"date", time.Now().Add(90 * time.Second).UTC().Format(http.TimeFormat), 88 * time.Second, 90 * time.Second},
+		{"past date", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), -2 * time.Hour, 0},
+		{"garbage", "soon", 0, 0},
+		{"fraction", "1.5", 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := RetryAfter(http.Header{"Retry-After": []string{tt.value}})
+			if got < tt.min || got > tt.max {
+				t.Errorf("RetryAfter(%q) = %s, want %s to %s", tt.value, got, tt.min, tt.max)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package scrape
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func (fl *Field) extract(s *goquery.Selection) interface{} {
+	if fl.Selector != "" {
+		s = s.Find(fl.Selector)
+	}
+
+	if fl.Table != nil {
+		return fl.Table.extract(s)
+	}
+
+	if !fl.All {
+		if s.Length() == 0 {
+			return nil
+		}
+		return fl.value(s.First())
+	}
+
+	values := []interface{}{}
+	s.Each(func(i int, one *goquery.Selection) {
+		if v := fl.value(one); v != nil {
+			values = append(values, v)
+		}
+	})
+
+	return fl.slice(values)
+}
+
+func (fl *Field) slice(values []interface{}) []interface{} {
+	from, to := fl.From, len(values)
+	if fl.To > 0 {
+		to = fl.To
+	} else if fl.To < 0 {
+		to = len(values) + fl.To
+	}
+	if to > len(values) {
+		to = len(values)
+	}
+	if from >= to {
+		return []interface{}{}
+	}
+
+	return values[from:to]
+}
+
+func (fl *Field) value(s *goquery.Selection) interface{} {
+	if len(fl.Fields) > 0 {
+		obj := map[string]interface{}{}
+		for name, field := range fl.Fields {
+			obj[name] = field.extract(s)
+		}
+		return obj
+	}
+
+	var text string
+	switch {
+	case fl.Attr != "":
+		var ok bool
+		if text, ok = s.Attr(fl.Attr); !ok {
+			return nil
+		}
+	case fl.HTML:
+		text, _ = s.Html()
+	default:
+		text = s.Text()
+	}
+	text = strings.TrimSpace(text)
+
+	if fl.re != nil {
+		m := fl.re.FindStringSubmatch(text)
+		if m == nil {
+			return nil
+		}
+		text = strings.TrimSpace(m[len(m)-1])
+	}
+
+	if fl.Split != nil {
+		return fl.Split.apply(text)
+	}
+
+	switch fl.Type {
+	case "number":
+		n, err := strconv.ParseFloat(number(text), 64)
+		if err != nil {
+			return nil
+		}
+		return n
+	case "int":
+		n, err := strconv.Atoi(number(text))
+		if err != nil {
+			return nil
+		}
+		return n
+	}
+
+	return text
+}
+
+func (sp *Split) apply(text string) []interface{} {
+	sections := []string{text}
+	if sp.Sections != "" {
+		sections = strings.Split(text, sp.Sections)
+	}
+
+	result := []interface{}{}
+	for _, section := range sections {
+		var name string
+		if sp.re != nil {
+			if loc := sp.re.FindStringSubmatchIndex(section); loc != nil && loc[0] == 0 {
+				n := len(loc)/2 - 1
+				if loc[2*n] >= 0 {
+					name = strings.TrimSpace(section[loc[2*n]:loc[2*n+1]])
+				}
+				section = section[loc[1]:]
+			}
+		}
+
+		values := []string{section}
+		for _, sep := range sp.Values {
+			next := []string{}
+			for _, v := range values {
+				next = append(next, strings.Split(v, sep)...)
+			}
+			values = next
+		}
+
+		kept := []string{}
+		for _, v := range values {
+			if v = strings.TrimSpace(v); v != "" {
+				kept = append(kept, v)
+			}
+		}
+
+		if len(kept) > 0 {
+			result = append(result, map[string]interface{}{"name": name, "values": kept})
+		}
+	}
+
+	return result
+}
+
+// number drops thousands separators and makes the decimal one a dot.
+func number(text string) string {
+	text = strings.Map(func(r rune) rune {
+		if r == ' ' || r == ' ' {
+			return -1
+		}
+		return r
+	}, text)
+
+	return strings.Replace(text, ",", ".", 1)
+}
+
+func (t *Table) extract(s *goquery.Selection) map[string]string {
+	result := map[string]string{}
+
+	if t.Rows != "" {
+		s.Find(t.Rows).Each(func(i int, row *goquery.Selection) {
+			key := text(row, t.Key)
+			if key != "" {
+				result[key] = text(row, t.Value)
+			}
+		})
+		return result
+	}
+
+	var key string
+	s.Find(t.Cells).Each(func(i int, cell *goquery.Selection) {
+		if i%2 == 0 {
+			key = text(cell, t.Key)
+		} else if key != "" {
+			result[key] = text(cell, t.Value)
+		}
+	})
+
+	return result
+}
+
+func text(s *goquery.Selection, selector string) string {
+	if selector != "" {
+		s = s.Find(selector)
+	}
+
+	return strings.TrimSpace(s.Text())
+}
+
+func links(doc *goquery.Document, selector string, attr string) []string {
+	if attr == "" {
+		attr = "href"
+	}
+
+	result := []string{}
+	doc.Find(selector).Each(func(i int, s *goquery.Selection) {
+		if href, ok := s.Attr(attr); ok && href != "" {
+			result = append(result, href)
+		}
+	})
+
+	return result
+}
+
+func unique(hrefs []string) []string {
+	seen := map[string]bool{}
+	result := []string{}
+
+	for _, href := range hrefs {
+		if !seen[href] {
+			seen[href] = true
+			result = append(result, href)
+		}
+	}
+
+	return result
+}
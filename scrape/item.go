@@ -0,0 +1,130 @@
+package scrape
+
+import (
+	"encoding/json"
+	"farma/canonical"
+	"farma/history"
+
+	"github.com/PuerkitoBio/goquery"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// item is a product page read by a spec. It is stored as its fields only,
+// with the href next to them.
+type item struct {
+	spec   *Spec
+	href   string
+	fields map[string]interface{}
+}
+
+func (s *Source) item(href string, doc *goquery.Selection) *item {
+	fields := map[string]interface{}{"href": href}
+	for name, field := range s.spec.Item.Fields {
+		fields[name] = field.extract(doc)
+	}
+
+	return &item{spec: s.spec, href: href, fields: fields}
+}
+
+func (i *item) MarshalJSON() ([]byte, error) {
+	return json.Marshal(i.fields)
+}
+
+func (i *item) MarshalBSON() ([]byte, error) {
+	return bson.Marshal(i.fields)
+}
+
+func (i *item) Key() string {
+	return i.href
+}
+
+func (i *item) str(name string) string {
+	s, _ := i.fields[name].(string)
+	return s
+}
+
+func (i *item) price() float64 {
+	price, _ := i.fields["price"].(float64)
+	return price
+}
+
+// Observation is nil for a page the price was not read from. The stock is
+// only known to specs with an in_stock field, which is in stock whenever
+// it matched.
+func (i *item) Observation() *history.Observation {
+	if i.price() <= 0 {
+		return nil
+	}
+
+	o := &history.Observation{
+		Key:      i.href,
+		Price:    i.price(),
+		Currency: i.spec.Currency,
+	}
+	if _, ok := i.spec.Item.Fields["in_stock"]; ok {
+		value := i.fields["in_stock"]
+		inStock := value != nil && value != ""
+		o.InStock = &inStock
+	}
+
+	return o
+}
+
+// Canonical reads title, price, groups and images by these field names,
+// looks the general info up in the attribute tables of the spec and copies
+// its extras.
+func (i *item) Canonical() *canonical.Medicament {
+	attrs := map[string]string{}
+	for _, name := range i.spec.Item.Attributes {
+		table, _ := i.fields[name].(map[string]string)
+		for key, value := range table {
+			attrs[key] = value
+		}
+	}
+
+	groups := []string{}
+	list, _ := i.fields["groups"].([]interface{})
+	for _, g := range list {
+		if s, ok := g.(string); ok {
+			groups = append(groups, s)
+		}
+	}
+
+	images := []*canonical.Image{}
+	list, _ = i.fields["images"].([]interface{})
+	for _, img := range list {
+		switch v := img.(type) {
+		case string:
+			images = append(images, &canonical.Image{Main: v})
+		case map[string]interface{}:
+			main, _ := v["main"].(string)
+			thumbnail, _ := v["thumbnail"].(string)
+			images = append(images, &canonical.Image{Main: main, Thumbnail: thumbnail})
+		}
+	}
+
+	var extras map[string]interface{}
+	for _, name := range i.spec.Item.Extras {
+		if value, ok := i.fields[name]; ok && value != nil {
+			if extras == nil {
+				extras = map[string]interface{}{}
+			}
+			extras[name] = value
+		}
+	}
+
+	return &canonical.Medicament{
+		Title:        i.str("title"),
+		MNN:          canonical.Lookup(attrs, canonical.MNN_NAMES...),
+		Manufacturer: canonical.Lookup(attrs, canonical.MANUFACTURER_NAMES...),
+		Dosage:       canonical.Lookup(attrs, canonical.DOSAGE_NAMES...),
+		PackCount:    canonical.Count(canonical.Lookup(attrs, canonical.PACK_NAMES...)),
+		Form:         canonical.Lookup(attrs, canonical.FORM_NAMES...),
+		Price:        i.price(),
+		Currency:     i.spec.Currency,
+		Prescription: canonical.Prescription(canonical.Lookup(attrs, canonical.PRESCRIPTION_NAMES...)),
+		Images:       images,
+		Groups:       groups,
+		Extras:       extras,
+	}
+}
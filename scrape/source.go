@@ -0,0 +1,162 @@
+package scrape
+
+import (
+	"context"
+	"farma/parser"
+	"farma/ratelimit"
+	"sync"
+)
+
+// Source crawls a pharmacy the way its spec says. It remembers the page
+// every frontier link was discovered on to send it as Referer.
+type Source struct {
+	spec     *Spec
+	referers sync.Map
+}
+
+// Register makes the spec a source of the parser.
+func Register(spec *Spec) {
+	parser.Register(&Source{spec: spec})
+}
+
+func (s *Source) Name() string {
+	return s.spec.Name
+}
+
+func (s *Source) Config() parser.Config {
+	middlewares := []parser.Middleware{
+		parser.UserAgents(s.spec.UserAgents...),
+		parser.Headers(s.spec.Headers),
+		parser.Headers(parser.DEFAULT_HEADERS),
+	}
+	if s.spec.Referer {
+		middlewares = append(middlewares, parser.Referer())
+	}
+	if s.spec.Log {
+		middlewares = append(middlewares, parser.Observe(nil, parser.LogExchange))
+	}
+
+	return parser.Config{
+		Limits:    ratelimit.Config{RPS: s.spec.RPS, Burst: s.spec.Burst},
+		Retry:     parser.DefaultRetryPolicy,
+		Transform: s.spec.Transform,
+		HTTP: parser.ClientConfig{
+			Cookies:     s.spec.Cookies,
+			Middlewares: middlewares,
+		},
+	}
+}
+
+// Discover runs the discovery steps from the start pages. Pages failing
+// are skipped, unless no page of a step could be read.
+func (s *Source) Discover(ctx context.Context, f *parser.FarmaParser) ([]string, error) {
+	pages := s.spec.Start
+
+	for _, step := range s.spec.Discover {
+		next := []string{}
+		var failed error
+		read := 0
+		for _, href := range pages {
+			doc, err := f.Page(s.referred(ctx, href), href, nil)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			} else if err != nil {
+				f.Skip("page `"+href+"`", err)
+				failed = err
+				continue
+			}
+			read++
+
+			for _, link := range links(doc, step.Links, step.Attr) {
+				link = f.Resolve(href, link)
+				next = append(next, link)
+				s.referers.Store(link, f.URL(href))
+			}
+		}
+		if read == 0 && failed != nil {
+			return nil, failed
+		}
+		pages = unique(next)
+	}
+
+	return pages, nil
+}
+
+// referred puts the page href was discovered on into ctx.
+func (s *Source) referred(ctx context.Context, href string) context.Context {
+	if referer, ok := s.referers.Load(href); ok {
+		return parser.WithReferer(ctx, referer.(string))
+	}
+
+	return ctx
+}
+
+// products returns the product links of a listing page and, with
+// withNexts, of the pages its pagination links to.
+func (s *Source) products(ctx context.Context, f *parser.FarmaParser, href string, withNexts bool) ([]string, error) {
+	doc, err := f.Page(ctx, href, nil)
+	if err != nil {
+		return nil, err
+	}
+	ctx = parser.WithReferer(ctx, f.URL(href))
+
+	listing := s.spec.Listing
+	result := []string{}
+	for _, selector := range listing.Products {
+		for _, link := range links(doc, selector, listing.Attr) {
+			result = append(result, f.Resolve(href, link))
+		}
+	}
+
+	if !withNexts || listing.Pagination == "" {
+		return result, nil
+	}
+
+	nexts := []string{}
+	for _, link := range links(doc, listing.Pagination, "") {
+		nexts = append(nexts, f.Resolve(href, link))
+	}
+
+	var mu sync.Mutex
+	f.Fanout(ctx, unique(nexts), func(nextHref string) {
+		more, err := s.products(ctx, f, nextHref, false)
+		if err != nil {
+			f.Skip("listing `"+nextHref+"`", err)
+			return
+		}
+
+		mu.Lock()
+		result = append(result, more...)
+		mu.Unlock()
+	})
+
+	return result, nil
+}
+
+// Parse emits the products of a listing page.
+func (s *Source) Parse(ctx context.Context, f *parser.FarmaParser, href string) error {
+	hrefs, err := s.products(s.referred(ctx, href), f, href, true)
+	if err != nil {
+		return err
+	}
+	ctx = parser.WithReferer(ctx, f.URL(href))
+
+	f.Fanout(ctx, unique(hrefs), func(itemHref string) {
+		if !f.Checkpoint.Claim(itemHref) {
+			return
+		}
+
+		doc, err := f.Page(ctx, itemHref, nil)
+		if err != nil {
+			f.Skip("item `"+itemHref+"`", err)
+			return
+		}
+
+		// The page is visited once the sink stored the item, a crash before
+		// that leaves it for the resumed crawl.
+		visit := func() { f.Checkpoint.Visit(itemHref) }
+		f.EmitAcked(ctx, s.item(itemHref, doc.Selection), visit)
+	})
+
+	return nil
+}
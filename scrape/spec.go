@@ -0,0 +1,236 @@
+package scrape
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/andybalholm/cascadia"
+)
+
+const SPEC_DIR string = "files/scrape"
+
+// Spec describes an HTML pharmacy: how to find its listing pages, how to
+// find products on them and what to read from a product page. Cookies keeps
+// the session cookies the site sets. UserAgents to rotate default to
+// parser.USER_AGENTS, Headers go on top of parser.DEFAULT_HEADERS. Referer
+// sends the page a link was found on, Log logs every request. Transform is
+// the jq program mapping items to the canonical model, instead of the
+// conventional fields.
+type Spec struct {
+	Name       string            `json:"name"`
+	RPS        float64           `json:"rps"`
+	Burst      int               `json:"burst"`
+	Currency   string            `json:"currency"`
+	Cookies    bool              `json:"cookies"`
+	UserAgents []string          `json:"user_agents"`
+	Headers    map[string]string `json:"headers"`
+	Referer    bool              `json:"referer"`
+	Log        bool              `json:"log"`
+	Transform  string            `json:"transform"`
+	Start      []string          `json:"start"`
+	Discover   []*Step           `json:"discover"`
+	Listing    *Listing          `json:"listing"`
+	Item       *Item             `json:"item"`
+}
+
+// Step follows the links matched on every page of the previous step, or on
+// the start pages. The links of the last step are the frontier.
+type Step struct {
+	Links string `json:"links"`
+	Attr  string `json:"attr"`
+}
+
+// Listing finds product links on a frontier page. Pagination links are
+// followed one level deep.
+type Listing struct {
+	Products   []string `json:"products"`
+	Attr       string   `json:"attr"`
+	Pagination string   `json:"pagination"`
+}
+
+// Item is what a product page is read into. Attributes name the table
+// fields the canonical general info is looked up in, later ones win.
+// Extras name the fields kept as they are in the canonical extras.
+type Item struct {
+	Fields     map[string]*Field `json:"fields"`
+	Attributes []string          `json:"attributes"`
+	Extras     []string          `json:"extras"`
+}
+
+// Field extracts one value of an item, the text of the first element
+// matching Selector by default. With Attr it is an attribute instead, with
+// HTML the inner markup. All collects every match, From and To then slice
+// the list, a negative To counting from the end. Regex keeps its last
+// submatch. Type "number" or "int" converts the value, Split cuts it into
+// named lists. Fields make every match an object, Table makes the field a
+// key/value map.
+type Field struct {
+	Selector string            `json:"selector"`
+	Attr     string            `json:"attr"`
+	HTML     bool              `json:"html"`
+	All      bool              `json:"all"`
+	From     int               `json:"from"`
+	To       int               `json:"to"`
+	Regex    string            `json:"regex"`
+	Type     string            `json:"type"`
+	Split    *Split            `json:"split"`
+	Fields   map[string]*Field `json:"fields"`
+	Table    *Table            `json:"table"`
+
+	re *regexp.Regexp
+}
+
+// Table reads key/value pairs either from Cells, alternating key and value
+// elements, or from Rows with Key and Value selectors inside each row. Key
+// and Value narrow cells down too.
+type Table struct {
+	Cells string `json:"cells"`
+	Rows  string `json:"rows"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Split cuts a value into sections at Sections. Name takes the section name
+// off its start as its last submatch, the rest is cut into values at every
+// separator of Values in turn. Each section is a {name, values} object,
+// empty values and sections without any are dropped.
+type Split struct {
+	Sections string   `json:"sections"`
+	Name     string   `json:"name"`
+	Values   []string `json:"values"`
+
+	re *regexp.Regexp
+}
+
+// Load reads and checks the spec at path.
+func Load(path string) (*Spec, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var spec *Spec
+	if err := json.Unmarshal(b, &spec); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if err := spec.compile(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return spec, nil
+}
+
+func (s *Spec) compile() error {
+	switch {
+	case s.Name == "":
+		return errors.New("no name")
+	case len(s.Start) == 0:
+		return errors.New("no start pages")
+	case s.Listing == nil || len(s.Listing.Products) == 0:
+		return errors.New("no product selectors")
+	case s.Item == nil || len(s.Item.Fields) == 0:
+		return errors.New("no item fields")
+	}
+
+	selectors := append([]string{s.Listing.Pagination}, s.Listing.Products...)
+	for _, step := range s.Discover {
+		if step.Links == "" {
+			return errors.New("discovery step without links")
+		}
+		selectors = append(selectors, step.Links)
+	}
+	if err := check(selectors...); err != nil {
+		return err
+	}
+
+	return compileFields(s.Item.Fields)
+}
+
+// check compiles the selectors up front, goquery silently matches nothing
+// with a broken one.
+func check(selectors ...string) error {
+	for _, selector := range selectors {
+		if selector == "" {
+			continue
+		}
+		if _, err := cascadia.Compile(selector); err != nil {
+			return fmt.Errorf("selector `%s`: %w", selector, err)
+		}
+	}
+
+	return nil
+}
+
+func compileFields(fields map[string]*Field) error {
+	for name, field := range fields {
+		if field.Regex != "" {
+			re, err := regexp.Compile(field.Regex)
+			if err != nil {
+				return fmt.Errorf("field `%s`: %w", name, err)
+			}
+			field.re = re
+		}
+
+		if err := check(field.Selector); err != nil {
+			return fmt.Errorf("field `%s`: %w", name, err)
+		}
+		if t := field.Table; t != nil {
+			if err := check(t.Cells, t.Rows, t.Key, t.Value); err != nil {
+				return fmt.Errorf("field `%s`: %w", name, err)
+			}
+		}
+
+		switch field.Type {
+		case "", "number", "int":
+		default:
+			return fmt.Errorf("field `%s`: unknown type `%s`", name, field.Type)
+		}
+		if split := field.Split; split != nil {
+			if field.Type != "" {
+				return fmt.Errorf("field `%s`: split of a %s", name, field.Type)
+			}
+			if split.Name != "" {
+				re, err := regexp.Compile(split.Name)
+				if err != nil {
+					return fmt.Errorf("field `%s`: split: %w", name, err)
+				}
+				split.re = re
+			}
+		}
+
+		if err := compileFields(field.Fields); err != nil {
+			return fmt.Errorf("field `%s`: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// RegisterDir registers a source for every spec in dir. A missing dir has
+// no specs.
+func RegisterDir(dir string) error {
+	if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		spec, err := Load(path)
+		if err != nil {
+			return err
+		}
+		Register(spec)
+	}
+
+	return nil
+}
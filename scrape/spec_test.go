@@ -0,0 +1,184 @@
+package scrape
+
+import (
+	"context"
+	"encoding/json"
+	"farma/egress"
+	"farma/parser"
+	"farma/ratelimit"
+	"farma/sink"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// The golden records were written by the hand-made hp and gz parsers these
+// specs replaced, crawling the same fixtures. The one change on purpose is
+// the name of the first hp sub attribute, which no longer starts with <b>.
+
+var HP_ROUTES = map[string]string{
+	"/ingredients/":                     "letters.html",
+	"/ingredients/?abc=a":               "letter_a.html",
+	"/ingredients/?abc=p":               "letter_p.html",
+	"/ingredients/ibuprofen/":           "ibuprofen.html",
+	"/ingredients/ibuprofen/?PAGEN_1=2": "ibuprofen_2.html",
+	"/ingredients/paracetamol/":         "paracetamol.html",
+	"/product/nurofen-200/":             "nurofen-200.html",
+	"/product/nurofen-400/":             "nurofen-400.html",
+	"/product/ibuprofen-akos/":          "ibuprofen-akos.html",
+	"/product/panadol/":                 "panadol.html",
+}
+
+var GZ_ROUTES = map[string]string{
+	"/":                         "root.html",
+	"/letter/a/":                "letter_a.html",
+	"/letter/n/":                "letter_n.html",
+	"/catalog/arbidol/":         "catalog_arbidol.html",
+	"/catalog/nurofen/":         "catalog_nurofen.html",
+	"/catalog/nazivin/":         "catalog_nazivin.html",
+	"/product/arbidol-100/":     "arbidol-100.html",
+	"/product/ingavirin/":       "ingavirin.html",
+	"/product/nurofen-200/":     "nurofen-200.html",
+	"/product/nurofen-express/": "nurofen-express.html",
+	"/product/ibuklin/":         "ibuklin.html",
+	"/product/nazivin-005/":     "nazivin-005.html",
+}
+
+// golden is a stored record. Data is left out where the raw shape changed
+// on purpose, the canonical one has to stay.
+type golden struct {
+	Key       string          `json:"key"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	Canonical json.RawMessage `json:"canonical"`
+}
+
+type collector struct {
+	mu      sync.Mutex
+	records []*sink.Record
+}
+
+func (c *collector) Write(ctx context.Context, record interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r := record.(*sink.Record)
+	c.records = append(c.records, r)
+	if r.Ack != nil {
+		r.Ack()
+	}
+
+	return nil
+}
+
+func (c *collector) Flush(ctx context.Context) error { return nil }
+func (c *collector) Close(ctx context.Context) error { return nil }
+
+func serve(t *testing.T, dir string, routes map[string]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, ok := routes[r.URL.RequestURI()]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, filepath.Join(dir, name))
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func crawl(t *testing.T, name string, routes map[string]string) []*sink.Record {
+	spec, err := Load(filepath.Join("..", SPEC_DIR, name+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := &Source{spec: spec}
+	server := serve(t, filepath.Join("testdata", name), routes)
+
+	records := &collector{}
+	failures := &collector{}
+	config := source.Config()
+	config.Source = name
+	config.BaseURL = server.URL
+	config.Limits = ratelimit.Config{RPS: 1000, Burst: 100}
+	config.Egress = egress.New(egress.Policy{Skip: true}, http.DefaultClient)
+	config.Sink = records
+	config.DeadLetters = failures
+	config.Rejects = failures
+
+	if _, err := parser.NewRawFarmaParser(config).Run(context.Background(), source); err != nil {
+		t.Fatal(err)
+	}
+	if len(failures.records) > 0 {
+		t.Errorf("%d records failed", len(failures.records))
+	}
+
+	sort.Slice(records.records, func(i, j int) bool {
+		return records.records[i].Key < records.records[j].Key
+	})
+
+	return records.records
+}
+
+func plain(t *testing.T, raw []byte) interface{} {
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		t.Fatal(err)
+	}
+
+	return v
+}
+
+func marshal(t *testing.T, v interface{}) []byte {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func testSpec(t *testing.T, name string, routes map[string]string) {
+	b, err := os.ReadFile(filepath.Join("testdata", name+".golden.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []*golden
+	if err := json.Unmarshal(b, &want); err != nil {
+		t.Fatal(err)
+	}
+
+	got := crawl(t, name, routes)
+	if len(got) != len(want) {
+		t.Fatalf("%d records, want %d", len(got), len(want))
+	}
+
+	for i, w := range want {
+		g := got[i]
+		if g.Key != w.Key {
+			t.Errorf("record %d: key %q, want %q", i, g.Key, w.Key)
+			continue
+		}
+		if len(w.Data) > 0 {
+			if data := marshal(t, g.Data); !reflect.DeepEqual(plain(t, data), plain(t, w.Data)) {
+				t.Errorf("%s: data\n%s\nwant\n%s", w.Key, data, w.Data)
+			}
+		}
+		if canonical := marshal(t, g.Canonical); !reflect.DeepEqual(plain(t, canonical), plain(t, w.Canonical)) {
+			t.Errorf("%s: canonical\n%s\nwant\n%s", w.Key, canonical, w.Canonical)
+		}
+	}
+}
+
+func TestHPSpec(t *testing.T) {
+	testSpec(t, "hp", HP_ROUTES)
+}
+
+func TestGZSpec(t *testing.T) {
+	testSpec(t, "gz", GZ_ROUTES)
+}
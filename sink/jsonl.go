@@ -0,0 +1,112 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// ACK_EVERY records a JSONL sink flushes and acks them.
+const ACK_EVERY int = 100
+
+// JSONL writes every record as one line of JSON. Records are acked once
+// they are flushed.
+type JSONL struct {
+	mu     sync.Mutex
+	w      *bufio.Writer
+	closer io.Closer
+	acks   []func()
+	stats  Stats
+}
+
+func NewJSONL(w io.Writer, closer io.Closer) *JSONL {
+	return &JSONL{w: bufio.NewWriter(w), closer: closer}
+}
+
+// NewJSONLFile appends records to the file at path, so a resumed crawl
+// continues the same file.
+func NewJSONLFile(path string) (*JSONL, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewJSONL(file, file), nil
+}
+
+func NewStdout() *JSONL {
+	return NewJSONL(os.Stdout, nil)
+}
+
+func NewStderr() *JSONL {
+	return NewJSONL(os.Stderr, nil)
+}
+
+func (s *JSONL) Write(ctx context.Context, record interface{}) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.w.Write(line); err != nil {
+		s.stats.Failed++
+		return err
+	}
+	if err := s.w.WriteByte('\n'); err != nil {
+		s.stats.Failed++
+		return err
+	}
+	s.stats.Written++
+
+	if ack := AckOf(record); ack != nil {
+		s.acks = append(s.acks, ack)
+		if len(s.acks) >= ACK_EVERY {
+			return s.flush()
+		}
+	}
+
+	return nil
+}
+
+func (s *JSONL) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.stats
+}
+
+func (s *JSONL) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.flush()
+}
+
+func (s *JSONL) flush() error {
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+
+	Acks(s.acks)
+	s.acks = nil
+
+	return nil
+}
+
+func (s *JSONL) Close(ctx context.Context) error {
+	if err := s.Flush(ctx); err != nil {
+		return err
+	}
+
+	if s.closer == nil {
+		return nil
+	}
+
+	return s.closer.Close()
+}
@@ -0,0 +1,63 @@
+package sink
+
+import (
+	"context"
+	"farma/canonical"
+	"time"
+)
+
+// Sink is where parsed records end up.
+type Sink interface {
+	Write(ctx context.Context, record interface{}) error
+	Flush(ctx context.Context) error
+	Close(ctx context.Context) error
+}
+
+// Keyed records know their natural key within the source, which lets sinks
+// update them in place instead of storing every crawl again.
+type Keyed interface {
+	Key() string
+}
+
+// Stats are the write counters of a sink.
+type Stats struct {
+	Written  int64
+	Failed   int64
+	Batches  int64
+	Duration time.Duration
+}
+
+// Statser is implemented by sinks that count what they wrote.
+type Statser interface {
+	Stats() Stats
+}
+
+// Record is a parsed item tagged with the crawl run it comes from, along
+// with its canonical form if the source can map it. Sinks call Ack once the
+// record is stored for good, never for one they failed to store.
+type Record struct {
+	Source    string                `json:"source"`
+	RunID     string                `json:"run_id"`
+	Key       string                `json:"key,omitempty"`
+	Data      interface{}           `json:"data"`
+	Canonical *canonical.Medicament `json:"canonical,omitempty"`
+	Ack       func()                `json:"-"`
+}
+
+// AckOf returns the Ack of a record, or nil for anything else.
+func AckOf(item interface{}) func() {
+	if r, ok := item.(*Record); ok {
+		return r.Ack
+	}
+
+	return nil
+}
+
+// Acks calls every non nil ack.
+func Acks(acks []func()) {
+	for _, ack := range acks {
+		if ack != nil {
+			ack()
+		}
+	}
+}
@@ -0,0 +1,16 @@
+// Package sources registers every pharmacy the crawler knows. HTML
+// pharmacies are specs in scrape.SPEC_DIR, others have to be imported here.
+package sources
+
+import (
+	"farma/scrape"
+	"log"
+
+	_ "farma/oz"
+)
+
+func init() {
+	if err := scrape.RegisterDir(scrape.SPEC_DIR); err != nil {
+		log.Fatalf("scraper specs not loaded: %v", err)
+	}
+}